@@ -0,0 +1,67 @@
+// Command breachfilter builds the bloom filter of breached passwords read by the server
+// from a Have I Been Pwned dataset, either a directory of range files or a single file
+// of HASH:COUNT lines. The filter is much smaller than the dataset but rejects some
+// passwords that never appeared in a breach, as often as the given false positive rate.
+//
+//	breachfilter -input pwnedpasswords -output configuration/breached.bloom -fp 0.001
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/44r0n/SessionManager/helpers"
+)
+
+func main() {
+	input := flag.String("input", "", "directory of range files or file of HASH:COUNT lines")
+	output := flag.String("output", "", "bloom filter file to write")
+	falsePositiveRate := flag.Float64("fp", 0.001, "false positive rate of the filter")
+	flag.Parse()
+
+	if *input == "" || *output == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *falsePositiveRate <= 0 || *falsePositiveRate >= 1 {
+		log.Fatalf("The false positive rate must be between 0 and 1")
+	}
+
+	var passwords uint64
+	err := helpers.ForEachBreachedHash(*input, func(sum [sha1.Size]byte) error {
+		passwords++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed reading %s: %v", *input, err)
+	}
+
+	filter := helpers.NewBloomFilter(passwords, *falsePositiveRate)
+	err = helpers.ForEachBreachedHash(*input, func(sum [sha1.Size]byte) error {
+		filter.Add(sum)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed reading %s: %v", *input, err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Cannot create %s: %v", *output, err)
+	}
+	writer := bufio.NewWriter(f)
+	size, err := filter.WriteTo(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Failed writing %s: %v", *output, err)
+	}
+	log.Printf("Wrote the filter of %d passwords to %s, %d bytes", passwords, *output, size)
+}
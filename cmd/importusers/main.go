@@ -0,0 +1,93 @@
+// Command importusers imports users from another system keeping their password hashes,
+// which are replaced by hashes of the configured algorithm on the first login of every
+// user. It reads a CSV file with the columns username, email, algorithm, hash and the
+// salt of the salted SHA-1 hashes. The algorithm is sha1, pbkdf2 or md5crypt.
+//
+//	importusers -config configuration/configuration.json -file users.csv
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+)
+
+func main() {
+	configFile := flag.String("config", "configuration/configuration.json", "configuration file")
+	file := flag.String("file", "", "CSV file of the users, the standard input by default")
+	batchSize := flag.Int("batch", 1000, "users imported per transaction")
+	flag.Parse()
+
+	input := io.Reader(os.Stdin)
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Cannot open %s: %v", *file, err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	repo, err := repository.NewUserRepository(helpers.GetConnString(*configFile))
+	if err != nil {
+		log.Fatalf("Cannot load user repository: %v", err)
+	}
+
+	reader := csv.NewReader(input)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	var read, imported, rejected int64
+	batch := make([]models.ImportedUser, 0, *batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		count, err := repo.ImportUsers(batch)
+		if err != nil {
+			log.Fatalf("Failed importing users, %d imported before: %v", imported, err)
+		}
+		imported += count
+		batch = batch[:0]
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Failed reading users: %v", err)
+		}
+		read++
+		line, _ := reader.FieldPos(0)
+		if len(record) < 4 || len(record) > 5 || record[0] == "" || record[1] == "" {
+			log.Printf("Line %d: expected username, email, algorithm, hash and optionally salt", line)
+			rejected++
+			continue
+		}
+		salt := ""
+		if len(record) == 5 {
+			salt = record[4]
+		}
+		hash, err := helpers.TagLegacyHash(record[2], record[3], salt)
+		if err != nil {
+			log.Printf("Line %d: invalid hash of user %s: %v", line, record[0], err)
+			rejected++
+			continue
+		}
+		batch = append(batch, models.ImportedUser{UserName: record[0], Email: record[1], PasswordHash: hash})
+		if len(batch) >= *batchSize {
+			flush()
+		}
+	}
+	flush()
+
+	log.Printf("Read %d users: %d imported, %d rejected and %d already existing", read, imported, rejected, read-rejected-imported)
+}
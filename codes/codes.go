@@ -10,3 +10,12 @@ const JSonError = -5
 const NoTokenProvided = -6
 const InvalidToken = -7
 const UserNotFound = -8
+const ExpiredToken = -9
+const InvalidRefreshToken = -10
+const SessionNotFound = -11
+const ExpiredSession = -12
+const InvalidCSRFToken = -13
+const WrongAudience = -14
+const InvalidPassword = -15
+const WrongClient = -16
+const WrongPassword = -17
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/44r0n/SessionManager/helpers"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// KeyController represents the controller that publishes the public token keys
+type KeyController struct{}
+
+// NewKeyController creates KeyController
+func NewKeyController() KeyController {
+	return KeyController{}
+}
+
+// JWKS controller function. Publishes the public keys as a JSON Web Key Set so
+// other services can verify the tokens without calling /Token/isValid
+func (kc *KeyController) JWKS(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log.Printf("/.well-known/jwks.json")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(helpers.GetJWKS()); err != nil {
+		log.Printf("Failed encoding JWKS: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/44r0n/SessionManager/helpers"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJWKS(t *testing.T) {
+	Convey("Given the keys of the server, it should publish them as a JSON Web Key Set", t, func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr := helpers.NewKeyring()
+		if err := kr.AddKey(&helpers.SigningKey{ID: "ec", Method: jwt.SigningMethodES256, SignKey: ecKey, VerifyKey: &ecKey.PublicKey}); err != nil {
+			t.Fatal(err)
+		}
+		hmacKey := helpers.NewHMACKey("hmac", []byte("a secret that should be long enough"))
+		hmacKey.VerifyUntil = time.Now().Add(time.Hour)
+		if err := kr.AddKey(hmacKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := kr.SetSigningKey("ec"); err != nil {
+			t.Fatal(err)
+		}
+		helpers.SetKeyring(kr)
+		defer helpers.SetKeyring(nil)
+
+		kc := NewKeyController()
+		req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := httprouter.New()
+
+		router.Handle("GET", "/.well-known/jwks.json", kc.JWKS)
+		router.ServeHTTP(rr, req)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(rr.Header().Get("Cache-Control"), ShouldEqual, "public, max-age=300")
+
+		jwks := helpers.JSONWebKeySet{}
+		err = json.NewDecoder(rr.Body).Decode(&jwks)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(jwks.Keys, ShouldHaveLength, 1)
+		key := jwks.Keys[0]
+		So(key.Kid, ShouldEqual, "ec")
+		So(key.Kty, ShouldEqual, "EC")
+		So(key.Alg, ShouldEqual, "ES256")
+		So(key.Crv, ShouldEqual, "P-256")
+
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		So(err, ShouldBeNil)
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		So(err, ShouldBeNil)
+		So(new(big.Int).SetBytes(x).Cmp(ecKey.PublicKey.X), ShouldEqual, 0)
+		So(new(big.Int).SetBytes(y).Cmp(ecKey.PublicKey.Y), ShouldEqual, 0)
+	})
+}
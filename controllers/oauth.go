@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Introspect controller function. Answers the state of the token sent in the
+// token form field as described in RFC 7662, so API gateways and resource servers
+// can use standard introspection clients.
+func (uc *UserController) Introspect(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log.Printf("/oauth/introspect")
+	if !authenticateClient(w, r) {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauthResponseToClient(w, http.StatusBadRequest, models.OAuthError{Error: "invalid_request",
+			ErrorDescription: "No token was provided"})
+		return
+	}
+
+	introspection, err := uc.userRepo.IntrospectToken(token)
+	if err != nil {
+		oauthResponseToClient(w, http.StatusInternalServerError, models.OAuthError{Error: "server_error",
+			ErrorDescription: "There was an error with the database"})
+		log.Printf("Error introspecting token: %v", err)
+		return
+	}
+	oauthResponseToClient(w, http.StatusOK, introspection)
+}
+
+// Revoke controller function. Revokes the access or refresh token sent in the token form
+// field as described in RFC 7009, along with its session. The optional token_type_hint
+// field tells which kind of token it is. Unknown tokens are answered with 200 too, so
+// the endpoint cannot be used to find out which tokens exist.
+func (uc *UserController) Revoke(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log.Printf("/oauth/revoke")
+	if !authenticateClient(w, r) {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauthResponseToClient(w, http.StatusBadRequest, models.OAuthError{Error: "invalid_request",
+			ErrorDescription: "No token was provided"})
+		return
+	}
+
+	if err := uc.userRepo.RevokeToken(token, r.PostFormValue("token_type_hint")); err != nil {
+		oauthResponseToClient(w, http.StatusServiceUnavailable, models.OAuthError{Error: "temporarily_unavailable",
+			ErrorDescription: "There was an error with the database"})
+		log.Printf("Error revoking token: %v", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient checks the HTTP Basic credentials of the client unless the OAuth
+// endpoints are open. Otherwise it writes an invalid_client error and returns false.
+func authenticateClient(w http.ResponseWriter, r *http.Request) bool {
+	if !helpers.ClientAuthenticationRequired() {
+		return true
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok && helpers.AuthenticateClient(id, secret) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="SessionManager"`)
+	oauthResponseToClient(w, http.StatusUnauthorized, models.OAuthError{Error: "invalid_client",
+		ErrorDescription: "The client could not be authenticated"})
+	return false
+}
+
+func oauthResponseToClient(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed encoding response to client: %v", err)
+	}
+}
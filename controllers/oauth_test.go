@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testClients = []helpers.ClientConfiguration{{ID: "gateway", Secret: "a secret"}}
+
+func simulateOAuthRequest(usrt repository.IUserRepositoryInterface, route string, form url.Values, t *testing.T) *httptest.ResponseRecorder {
+	return simulateOAuthRequestAs(usrt, route, form, "gateway", "a secret", t)
+}
+
+func simulateOAuthRequestAs(usrt repository.IUserRepositoryInterface, route string, form url.Values, id, secret string, t *testing.T) *httptest.ResponseRecorder {
+	uc := NewUserController(usrt)
+	req, err := http.NewRequest("POST", route, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(id, secret)
+
+	rr := httptest.NewRecorder()
+	router := httprouter.New()
+
+	switch route {
+	case "/oauth/introspect":
+		router.Handle("POST", route, uc.Introspect)
+	case "/oauth/revoke":
+		router.Handle("POST", route, uc.Revoke)
+	}
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIntrospect(t *testing.T) {
+	helpers.SetClients(testClients)
+	defer helpers.SetClients(nil)
+
+	Convey("Given an active token, it should be introspected", t, func() {
+		repo := &UserRepositoryTest{validUser: true, client: models.Client{ClientID: "gateway", Scope: "read write"}}
+		rr := simulateOAuthRequest(repo, "/oauth/introspect", url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}}, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(rr.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+
+		response := map[string]interface{}{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response["active"], ShouldEqual, true)
+		So(response["sub"], ShouldEqual, "testID")
+		So(response["sid"], ShouldEqual, "session")
+		So(response["client_id"], ShouldEqual, "gateway")
+		So(response["scope"], ShouldEqual, "read write")
+		So(response["exp"], ShouldBeGreaterThan, response["iat"])
+	})
+
+	Convey("Given an inactive token, only active should be answered", t, func() {
+		rr := simulateOAuthRequest(&UserRepositoryTest{}, "/oauth/introspect", url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}}, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		response := map[string]interface{}{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response, ShouldResemble, map[string]interface{}{"active": false})
+	})
+
+	Convey("Given no token, it should return a bad request", t, func() {
+		rr := simulateOAuthRequest(&UserRepositoryTest{}, "/oauth/introspect", url.Values{}, t)
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+		response := models.OAuthError{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Error, ShouldEqual, "invalid_request")
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	helpers.SetClients(testClients)
+	defer helpers.SetClients(nil)
+
+	Convey("Given a refresh token, it should be revoked with its hint", t, func() {
+		repo := &UserRepositoryTest{validUser: true}
+		rr := simulateOAuthRequest(repo, "/oauth/revoke", url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}, "token_type_hint": {"refresh_token"}}, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.Len(), ShouldEqual, 0)
+		So(repo.revokedHint, ShouldEqual, models.RefreshTokenHint)
+	})
+
+	Convey("Given an unknown token, it should return ok anyway", t, func() {
+		rr := simulateOAuthRequest(&UserRepositoryTest{}, "/oauth/revoke", url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}}, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	})
+
+	Convey("Given no token, it should return a bad request", t, func() {
+		rr := simulateOAuthRequest(&UserRepositoryTest{}, "/oauth/revoke", url.Values{}, t)
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+		response := models.OAuthError{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Error, ShouldEqual, "invalid_request")
+	})
+}
+
+func TestOAuthClientAuthentication(t *testing.T) {
+	Convey("Given no configured clients, the OAuth endpoints should refuse every call", t, func() {
+		repo := &UserRepositoryTest{validUser: true}
+		for _, route := range []string{"/oauth/introspect", "/oauth/revoke"} {
+			rr := simulateOAuthRequest(repo, route, url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}}, t)
+			So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+			response := models.OAuthError{}
+			err := json.NewDecoder(rr.Body).Decode(&response)
+			if err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Error, ShouldEqual, "invalid_client")
+		}
+		So(repo.revokedHint, ShouldBeEmpty)
+	})
+
+	Convey("Given configured clients, wrong credentials should be refused", t, func() {
+		helpers.SetClients(testClients)
+		defer helpers.SetClients(nil)
+
+		rr := simulateOAuthRequestAs(&UserRepositoryTest{validUser: true}, "/oauth/introspect", url.Values{"token": {"qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"}}, "gateway", "another secret", t)
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		So(rr.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+	})
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/44r0n/SessionManager/codes"
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ChangePassword controller function. Replaces the password of the user of the token,
+// checking the new one like Register does
+func (uc *UserController) ChangePassword(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	response := models.Response{Error: codes.Unknown}
+	responseData := models.ResponseData{Data: response}
+	log.Printf("/Password")
+	userID, _, ok := uc.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	change := models.PasswordChangeRequest{}
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.JSonError,
+			Description: "Failed decoding json"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed decoding json: %v", err)
+		return
+	}
+
+	if change.OldPassword == "" || change.NewPassword == "" {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.JSonError,
+			Description: "Some params required are empty"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	userName, email, pass, err := uc.userRepo.GetNameEmailAndPassword(userID)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed getting user %v: %v", userID, err)
+		return
+	}
+
+	if pass == "" {
+		response = models.Response{Status: http.StatusNotFound,
+			Error:       codes.UserNotFound,
+			Description: "User not found"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	if err := helpers.CheckHash(pass, change.OldPassword); err != nil {
+		response = models.Response{Status: http.StatusForbidden,
+			Error:       codes.WrongPassword,
+			Description: "The password is wrong"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	violations, err := helpers.CheckNewPassword(change.NewPassword, userName, email)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.Unknown,
+			Description: "There was an error checking the password"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed checking if the password is breached: %v", err)
+		return
+	}
+	if len(violations) > 0 {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.InvalidPassword,
+			Description: "The password does not meet the policy",
+			Fields:      map[string][]string{"NewPassword": violations}}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	newHash, err := helpers.GenerateHash(change.NewPassword)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.Unknown,
+			Description: "There was an error hashing the password"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed hashing password of user %v: %v", userID, err)
+		return
+	}
+
+	updated, err := uc.userRepo.UpdatePassword(userID, pass, newHash)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed updating password of user %v: %v", userID, err)
+		return
+	}
+
+	if !updated {
+		response = models.Response{Status: http.StatusConflict,
+			Error:       codes.WrongPassword,
+			Description: "The password has changed meanwhile"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	response = models.Response{Status: http.StatusOK, Error: codes.Ok}
+	responseData.Data = response
+	uc.responseToClient(w, responseData)
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/44r0n/SessionManager/codes"
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func simulateChangePassword(usrt repository.IUserRepositoryInterface, jsonChange []byte, t *testing.T) *httptest.ResponseRecorder {
+	uc := NewUserController(usrt)
+	req, err := http.NewRequest("POST", "/Password", bytes.NewBuffer(jsonChange))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := helpers.Tokenize("testID", "session", "", helpers.CustomClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := httprouter.New()
+	router.Handle("POST", "/Password", uc.ChangePassword)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestChangePassword(t *testing.T) {
+	Convey("Given a user with a password", t, func() {
+		hash, err := helpers.GenerateHash("oldPassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: hash}
+
+		Convey("It should be changed with the right password", func() {
+			rr := simulateChangePassword(repo, []byte(`{"OldPassword":"oldPassword","NewPassword":"newPassword"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(helpers.CheckHash(repo.rehashed, "newPassword"), ShouldBeNil)
+		})
+
+		Convey("It should not be changed with a wrong password", func() {
+			rr := simulateChangePassword(repo, []byte(`{"OldPassword":"wrongPassword","NewPassword":"newPassword"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusForbidden)
+
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.WrongPassword)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+
+		Convey("A new password breaking the policy should be refused", func() {
+			rr := simulateChangePassword(repo, []byte(`{"OldPassword":"oldPassword","NewPassword":"short"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.InvalidPassword)
+			So(response.Data.Fields["NewPassword"], ShouldNotBeEmpty)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+
+		Convey("A breached new password should be refused", func() {
+			helpers.SetBreachedPasswords(breachedPasswordsTest{})
+			defer helpers.SetBreachedPasswords(nil)
+
+			rr := simulateChangePassword(repo, []byte(`{"OldPassword":"oldPassword","NewPassword":"breachedPassword"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+			helpers.SetBreachedPasswords(breachedPasswordsTest{err: errors.New("missing range file")})
+			rr = simulateChangePassword(repo, []byte(`{"OldPassword":"oldPassword","NewPassword":"newPassword"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+	})
+}
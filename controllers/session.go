@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/44r0n/SessionManager/codes"
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetSessions controller function. Lists the sessions of the user of the token
+func (uc *UserController) GetSessions(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	response := models.Response{Error: codes.Unknown}
+	responseData := models.ResponseData{Data: response}
+	log.Printf("/Sessions")
+	userID, token, ok := uc.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := uc.userRepo.GetSessions(userID, token)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Error getting sessions: %v", err)
+		return
+	}
+
+	response = models.Response{Status: http.StatusOK,
+		Error:    codes.Ok,
+		Sessions: sessions}
+	responseData.Data = response
+	uc.responseToClient(w, responseData)
+}
+
+// DeleteSession controller function. Revokes the given session of the user of the token
+func (uc *UserController) DeleteSession(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	response := models.Response{Error: codes.Unknown}
+	responseData := models.ResponseData{Data: response}
+	log.Printf("/Sessions/:id")
+	userID, _, ok := uc.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := uc.userRepo.DeleteSession(userID, p.ByName("id"))
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Error deleting session: %v", err)
+		return
+	}
+
+	if !deleted {
+		response = models.Response{Status: http.StatusNotFound,
+			Error:       codes.SessionNotFound,
+			Description: "Session not found"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	response = models.Response{Status: http.StatusOK, Error: codes.Ok}
+	responseData.Data = response
+	uc.responseToClient(w, responseData)
+}
+
+// LogoutAll controller function. Revokes all the sessions of the user of the token
+func (uc *UserController) LogoutAll(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	response := models.Response{Error: codes.Unknown}
+	responseData := models.ResponseData{Data: response}
+	log.Printf("/Logout/all")
+	userID, _, ok := uc.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := uc.userRepo.DeleteSessions(userID); err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Error loging out all sessions: %v", err)
+		return
+	}
+
+	if helpers.SessionCookieEnabled() {
+		http.SetCookie(w, helpers.ExpiredSessionCookie())
+	}
+
+	response = models.Response{Status: http.StatusOK, Error: codes.Ok}
+	responseData.Data = response
+	uc.responseToClient(w, responseData)
+}
+
+// CSRF controller function. Returns the CSRF token that state changing requests
+// authenticated by the session cookie must send in the X-CSRF-Token header
+func (uc *UserController) CSRF(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log.Printf("/csrf")
+	_, token, ok := uc.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	response := models.Response{Status: http.StatusOK,
+		Error:     codes.Ok,
+		CSRFToken: helpers.CSRFToken(token)}
+	uc.responseToClient(w, models.ResponseData{Data: response})
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/44r0n/SessionManager/codes"
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func simulateSessionRequest(usrt repository.IUserRepositoryInterface, method, route, url, token string, t *testing.T) *httptest.ResponseRecorder {
+	uc := NewUserController(usrt)
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := httprouter.New()
+
+	switch route {
+	case "/Sessions":
+		router.Handle(method, route, uc.GetSessions)
+	case "/Sessions/:id":
+		router.Handle(method, route, uc.DeleteSession)
+	case "/Logout/all":
+		router.Handle(method, route, uc.LogoutAll)
+	case "/csrf":
+		router.Handle(method, route, uc.CSRF)
+	}
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func testSessionsRepository() *UserRepositoryTest {
+	now := time.Now().UTC()
+	return &UserRepositoryTest{validUser: true, sessions: []models.Session{
+		{ID: "session1", DateCreated: now, LastDateUsed: now, Current: true},
+		{ID: "session2", Client: models.Client{DeviceName: "100%d phone", UserAgent: "Agent %s"}, DateCreated: now.Add(-time.Hour), LastDateUsed: now.Add(-time.Minute)},
+	}}
+}
+
+func TestGetSessions(t *testing.T) {
+	Convey("Given a valid token, it should list the sessions of its user", t, func() {
+		token, err := helpers.Tokenize("testID", "session", "", helpers.CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := simulateSessionRequest(testSessionsRepository(), "GET", "/Sessions", "/Sessions", token, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		response := models.ResponseData{}
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.Ok)
+		So(len(response.Data.Sessions), ShouldEqual, 2)
+		So(response.Data.Sessions[0].ID, ShouldEqual, "session1")
+		So(response.Data.Sessions[0].Current, ShouldBeTrue)
+		So(response.Data.Sessions[1].DeviceName, ShouldEqual, "100%d phone")
+		So(response.Data.Sessions[1].UserAgent, ShouldEqual, "Agent %s")
+	})
+
+	Convey("Given an invalid token, it should not list any session", t, func() {
+		repo := testSessionsRepository()
+		repo.validUser = false
+		rr := simulateSessionRequest(repo, "GET", "/Sessions", "/Sessions", "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t)
+		So(rr.Code, ShouldEqual, http.StatusNotFound)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.InvalidToken)
+		So(response.Data.Sessions, ShouldBeEmpty)
+	})
+}
+
+func TestDeleteSession(t *testing.T) {
+	Convey("Given a valid token", t, func() {
+		token, err := helpers.Tokenize("testID", "session", "", helpers.CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("It can revoke one of its sessions", func() {
+			rr := simulateSessionRequest(testSessionsRepository(), "DELETE", "/Sessions/:id", "/Sessions/session2", token, t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("It cannot revoke an unknown session", func() {
+			rr := simulateSessionRequest(testSessionsRepository(), "DELETE", "/Sessions/:id", "/Sessions/other", token, t)
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+
+			response := models.ResponseData{}
+			err := json.NewDecoder(rr.Body).Decode(&response)
+			if err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.SessionNotFound)
+			So(response.Data.Description, ShouldEqual, "Session not found")
+		})
+
+		Convey("It can revoke all its sessions", func() {
+			rr := simulateSessionRequest(testSessionsRepository(), "POST", "/Logout/all", "/Logout/all", token, t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestCSRF(t *testing.T) {
+	Convey("Given a valid token, it should return its CSRF token", t, func() {
+		token, err := helpers.Tokenize("testID", "session", "", helpers.CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := simulateSessionRequest(testSessionsRepository(), "GET", "/csrf", "/csrf", token, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		response := models.ResponseData{}
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.Ok)
+		So(response.Data.CSRFToken, ShouldEqual, helpers.CSRFToken(token))
+	})
+
+	Convey("Given a cookie authenticated request, it should need the CSRF token to change the state", t, func() {
+		uc := NewUserController(testSessionsRepository())
+		const token = "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			req, err := http.NewRequest(method, "/Logout", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			So(uc.checkCSRF(rr, req, token), ShouldEqual, method == "GET")
+
+			req.Header.Set(helpers.CSRFHeader, helpers.CSRFToken(token))
+			So(uc.checkCSRF(httptest.NewRecorder(), req, token), ShouldBeTrue)
+			if method != "GET" {
+				So(rr.Code, ShouldEqual, http.StatusForbidden)
+			}
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// StatsController represents the controller that publishes the counters of the background jobs and caches
+type StatsController struct {
+	reaper *repository.Reaper
+	cache  *repository.CachedUserRepository
+}
+
+// NewStatsController creates StatsController publishing the stats of the given reaper and
+// cache of tokens, nil if it is disabled
+func NewStatsController(reaper *repository.Reaper, cache *repository.CachedUserRepository) StatsController {
+	return StatsController{reaper: reaper, cache: cache}
+}
+
+// Stats controller function. Publishes the results of the last run of the reaper and the
+// counters of the cache of tokens to the clients allowed to call the OAuth endpoints
+func (sc *StatsController) Stats(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log.Printf("/stats")
+	if !authenticateClient(w, r) {
+		return
+	}
+
+	reaper := sc.reaper.Stats()
+	stats := models.Stats{Reaper: models.ReaperStats{LastRun: reaper.LastRun,
+		Duration:             reaper.Duration.String(),
+		Batches:              reaper.Batches,
+		SessionsDeleted:      reaper.SessionsDeleted,
+		RefreshTokensDeleted: reaper.RefreshTokensDeleted,
+		RevokedTokensDeleted: reaper.RevokedTokensDeleted}}
+	if reaper.Err != nil {
+		stats.Reaper.Error = reaper.Err.Error()
+	}
+	if sc.cache != nil {
+		cache := sc.cache.Stats()
+		stats.TokenCache = &models.TokenCacheStats{Hits: cache.Hits, Misses: cache.Misses, Size: cache.Size}
+	}
+	oauthResponseToClient(w, http.StatusOK, stats)
+}
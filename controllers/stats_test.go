@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+	"github.com/44r0n/SessionManager/repository"
+
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type expiredSessionsTest struct{}
+
+func (est expiredSessionsTest) DeleteExpiredSessions(batchSize int) (int64, error) {
+	return 2, nil
+}
+
+func (est expiredSessionsTest) DeleteExpiredRefreshTokens(batchSize int) (int64, error) {
+	return 1, nil
+}
+
+func (est expiredSessionsTest) DeleteExpiredRevokedTokens(batchSize int) (int64, error) {
+	return 0, nil
+}
+
+func simulateStatsRequest(sc StatsController, id, secret string, t *testing.T) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(id, secret)
+
+	rr := httptest.NewRecorder()
+	router := httprouter.New()
+	router.Handle("GET", "/stats", sc.Stats)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestStats(t *testing.T) {
+	helpers.SetClients(testClients)
+	defer helpers.SetClients(nil)
+
+	Convey("Given a reaper that has run, its results should be published", t, func() {
+		reaper := repository.NewReaper(expiredSessionsTest{}, time.Hour, 500)
+		reaper.Run()
+		rr := simulateStatsRequest(NewStatsController(reaper, nil), "gateway", "a secret", t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		stats := models.Stats{}
+		err := json.NewDecoder(rr.Body).Decode(&stats)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(stats.Reaper.SessionsDeleted, ShouldEqual, 2)
+		So(stats.Reaper.RefreshTokensDeleted, ShouldEqual, 1)
+		So(stats.Reaper.Batches, ShouldEqual, 3)
+		So(stats.Reaper.LastRun.IsZero(), ShouldBeFalse)
+		So(stats.Reaper.Error, ShouldBeEmpty)
+		So(stats.TokenCache, ShouldBeNil)
+	})
+
+	Convey("Given a cache of tokens, its counters should be published", t, func() {
+		reaper := repository.NewReaper(expiredSessionsTest{}, time.Hour, 500)
+		cache := repository.NewCachedUserRepository(&UserRepositoryTest{validUser: true}, 10, time.Minute)
+		for i := 0; i < 3; i++ {
+			cache.CheckToken("qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", "", "")
+		}
+		rr := simulateStatsRequest(NewStatsController(reaper, cache), "gateway", "a secret", t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		stats := models.Stats{}
+		err := json.NewDecoder(rr.Body).Decode(&stats)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(stats.TokenCache, ShouldResemble, &models.TokenCacheStats{Hits: 2, Misses: 1, Size: 1})
+	})
+
+	Convey("Given wrong credentials, the stats should not be published", t, func() {
+		reaper := repository.NewReaper(expiredSessionsTest{}, time.Hour, 500)
+		rr := simulateStatsRequest(NewStatsController(reaper, nil), "gateway", "another secret", t)
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/44r0n/SessionManager/helpers"
 
@@ -58,6 +58,26 @@ func (uc *UserController) Register(w http.ResponseWriter, r *http.Request, p htt
 		return
 	}
 
+	violations, err := helpers.CheckNewPassword(u.Password, u.UserName, u.Email)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.Unknown,
+			Description: "There was an error checking the password"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed checking if the password is breached: %v", err)
+		return
+	}
+	if len(violations) > 0 {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.InvalidPassword,
+			Description: "The password does not meet the policy",
+			Fields:      map[string][]string{"Password": violations}}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
 	existsUsername, e := uc.userRepo.ExistsUsername(u.UserName)
 	if e != nil {
 		response = models.Response{Status: http.StatusInternalServerError,
@@ -121,7 +141,7 @@ func (uc *UserController) Login(w http.ResponseWriter, r *http.Request, p httpro
 	response := models.Response{Error: codes.Unknown}
 	responseData := models.ResponseData{Data: response}
 	log.Printf("/Login")
-	u := models.User{}
+	u := models.LoginRequest{}
 	err := json.NewDecoder(r.Body).Decode(&u)
 	if err != nil {
 		response = models.Response{Status: http.StatusInternalServerError,
@@ -164,15 +184,71 @@ func (uc *UserController) Login(w http.ResponseWriter, r *http.Request, p httpro
 		return
 	}
 
-	token, err := helpers.Tokenize(userID)
+	if !helpers.AudienceAllowed(u.Audience) {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.WrongAudience,
+			Description: "The audience is not allowed"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	if !helpers.ClientScopeAllowed(u.ClientID, u.Scope) {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.WrongClient,
+			Description: "The client or scope is not allowed"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	sessionID, err := helpers.NewUUID()
+	if err != nil {
+		responseData.Data = uc.tokenGenerationErrorResponse()
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed generating session id: %v", err)
+		return
+	}
+
+	claims, err := uc.userClaims(userID)
 	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed getting claims of user %v: %v", userID, err)
+		return
+	}
+
+	if helpers.NeedsRehash(pass) {
+		uc.rehashPassword(userID, pass, u.Password)
+	}
+
+	token, err := helpers.IssueToken(userID, sessionID, u.Audience, claims)
+	if err != nil {
+		responseData.Data = uc.tokenGenerationErrorResponse()
+		uc.responseToClient(w, responseData)
 		log.Printf("Failed generating token: %v", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	refreshToken, err := helpers.GenerateRefreshToken()
+	if err != nil {
+		responseData.Data = uc.tokenGenerationErrorResponse()
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed generating refresh token: %v", err)
+		return
+	}
+
 	if token != "" {
-		err = uc.userRepo.CreateToken(userID, token)
+		client := models.Client{IP: helpers.ClientIP(r),
+			UserAgent:  r.UserAgent(),
+			DeviceName: u.DeviceName,
+			ClientID:   u.ClientID,
+			Scope:      u.Scope,
+			Audience:   u.Audience}
+		err = uc.userRepo.CreateToken(sessionID, userID, token, refreshToken, client)
 		if err != nil {
 			response = models.Response{Status: http.StatusInternalServerError,
 				Error:       codes.DataBaseError,
@@ -182,8 +258,15 @@ func (uc *UserController) Login(w http.ResponseWriter, r *http.Request, p httpro
 			log.Printf("Failed creating token: %v", err)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, `{"Response":{"Status":`+strconv.Itoa(http.StatusOK)+`,"Token":"`+token+`","Error":`+strconv.Itoa(codes.Ok)+`}}`)
+		if helpers.SessionCookieEnabled() {
+			http.SetCookie(w, helpers.NewSessionCookie(token))
+		}
+		response = models.Response{Status: http.StatusOK,
+			Error:        codes.Ok,
+			Token:        token,
+			RefreshToken: refreshToken}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
 		return
 	}
 
@@ -194,6 +277,133 @@ func (uc *UserController) Login(w http.ResponseWriter, r *http.Request, p httpro
 	uc.responseToClient(w, responseData)
 }
 
+//RefreshToken controller function. Exchanges a refresh token for a new token and refresh token
+func (uc *UserController) RefreshToken(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	response := models.Response{Error: codes.Unknown}
+	responseData := models.ResponseData{Data: response}
+	log.Printf("/Token/refresh")
+	refresh := models.RefreshRequest{}
+	err := json.NewDecoder(r.Body).Decode(&refresh)
+	if err != nil || refresh.RefreshToken == "" {
+		response = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.NoTokenProvided,
+			Description: "No refresh token was provided"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		return
+	}
+
+	userID, session, err := uc.userRepo.GetRefreshTokenSession(refresh.RefreshToken)
+	if err == repository.ErrRefreshTokenInvalid {
+		responseData.Data = uc.refreshTokenErrorResponse()
+		uc.responseToClient(w, responseData)
+		return
+	}
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed getting refresh token: %v", err)
+		return
+	}
+
+	claims, err := uc.userClaims(userID)
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed getting claims of user %v: %v", userID, err)
+		return
+	}
+
+	token, err := helpers.IssueToken(userID, session.ID, session.Audience, claims)
+	if err != nil {
+		responseData.Data = uc.tokenGenerationErrorResponse()
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed generating token: %v", err)
+		return
+	}
+
+	newRefreshToken, err := helpers.GenerateRefreshToken()
+	if err != nil {
+		responseData.Data = uc.tokenGenerationErrorResponse()
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed generating refresh token: %v", err)
+		return
+	}
+
+	err = uc.userRepo.RotateRefreshToken(refresh.RefreshToken, token, newRefreshToken)
+	if err == repository.ErrRefreshTokenReused || err == repository.ErrRefreshTokenInvalid {
+		if err == repository.ErrRefreshTokenReused {
+			log.Printf("Refresh token of user %v reused, its session has been revoked", userID)
+		}
+		responseData.Data = uc.refreshTokenErrorResponse()
+		uc.responseToClient(w, responseData)
+		return
+	}
+	if err != nil {
+		response = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		responseData.Data = response
+		uc.responseToClient(w, responseData)
+		log.Printf("Failed rotating refresh token: %v", err)
+		return
+	}
+
+	if helpers.SessionCookieEnabled() {
+		http.SetCookie(w, helpers.NewSessionCookie(token))
+	}
+	response = models.Response{Status: http.StatusOK,
+		Error:        codes.Ok,
+		Token:        token,
+		RefreshToken: newRefreshToken}
+	responseData.Data = response
+	uc.responseToClient(w, responseData)
+}
+
+// rehashPassword replaces the hash of a just checked password by one of the configured
+// algorithm and parameters. Failures are only logged, the old hash is still valid.
+func (uc *UserController) rehashPassword(userID, oldHash, password string) {
+	newHash, err := helpers.GenerateHash(password)
+	if err != nil {
+		log.Printf("Failed rehashing password of user %v: %v", userID, err)
+		return
+	}
+	if _, err := uc.userRepo.UpdatePassword(userID, oldHash, newHash); err != nil {
+		log.Printf("Failed updating password of user %v: %v", userID, err)
+	}
+}
+
+// userClaims returns the roles of the given user along with the claims of the enrichers
+func (uc *UserController) userClaims(userID string) (helpers.CustomClaims, error) {
+	roles, err := uc.userRepo.GetRoles(userID)
+	if err != nil {
+		return helpers.CustomClaims{}, err
+	}
+	claims := helpers.CustomClaims{Roles: roles}
+	if err := helpers.EnrichClaims(userID, &claims); err != nil {
+		return helpers.CustomClaims{}, err
+	}
+	return claims, nil
+}
+
+func (uc *UserController) refreshTokenErrorResponse() models.Response {
+	return models.Response{Status: http.StatusUnauthorized,
+		Error:       codes.InvalidRefreshToken,
+		Description: "The refresh token is invalid"}
+}
+
+func (uc *UserController) tokenGenerationErrorResponse() models.Response {
+	return models.Response{Status: http.StatusInternalServerError,
+		Error:       codes.Unknown,
+		Description: "There was an error generating the token"}
+}
+
 func (uc *UserController) responseToClient(w http.ResponseWriter, response models.ResponseData) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(response.Data.Status)
@@ -202,7 +412,7 @@ func (uc *UserController) responseToClient(w http.ResponseWriter, response model
 		log.Fatalf("Failed decodign response to client. Error: %v", err)
 		return
 	}
-	fmt.Fprintf(w, string(json[:]))
+	w.Write(json)
 }
 
 //Logout controller function
@@ -210,25 +420,29 @@ func (uc *UserController) Logout(w http.ResponseWriter, r *http.Request, p httpr
 	response := models.Response{Error: codes.Unknown}
 	responseData := models.ResponseData{Data: response}
 	log.Printf("/Logout")
-	token := uc.checkTokenHeader(w, r)
+	token, fromCookie := uc.checkTokenHeader(w, r)
 	if token == "" {
 		response = models.Response{Status: http.StatusBadRequest,
 			Error:       codes.NoTokenProvided,
 			Description: "No token was provided"}
 		responseData.Data = response
+		uc.bearerChallenge(w, nil)
 		uc.responseToClient(w, responseData)
 		return
 	}
-
-	userID, err := helpers.GetFromToken(token)
-	if err != nil {
-		response = models.Response{Status: http.StatusInternalServerError,
-			Error:       codes.InvalidToken,
-			Description: "The token is invalid"}
+	if fromCookie && !uc.checkCSRF(w, r, token) {
 		return
 	}
 
-	if err := uc.userRepo.DeleteToken(userID, token); err != nil {
+	if !helpers.IsOpaqueToken(token) {
+		if _, err := helpers.GetFromToken(token); err != nil {
+			responseData.Data = uc.tokenErrorResponse(w, err)
+			uc.responseToClient(w, responseData)
+			return
+		}
+	}
+
+	if err := uc.userRepo.DeleteToken(token); err != nil {
 		response = models.Response{Status: http.StatusInternalServerError,
 			Error:       codes.DataBaseError,
 			Description: "There was an error with the database"}
@@ -238,6 +452,10 @@ func (uc *UserController) Logout(w http.ResponseWriter, r *http.Request, p httpr
 		return
 	}
 
+	if helpers.SessionCookieEnabled() {
+		http.SetCookie(w, helpers.ExpiredSessionCookie())
+	}
+
 	response = models.Response{Status: http.StatusOK,
 		Error:       codes.Ok,
 		Description: ""}
@@ -245,34 +463,154 @@ func (uc *UserController) Logout(w http.ResponseWriter, r *http.Request, p httpr
 	uc.responseToClient(w, responseData)
 }
 
-func (uc *UserController) checkTokenHeader(w http.ResponseWriter, r *http.Request) string {
+// checkTokenHeader returns the token of the Authorization header, sent with the Bearer
+// scheme or alone. Without the header the token is taken from the session cookie if
+// enabled, and the returned bool is true.
+func (uc *UserController) checkTokenHeader(w http.ResponseWriter, r *http.Request) (string, bool) {
 	arraytoken, exists := r.Header["Authorization"]
-	if !exists {
-		return ""
+	if !exists || len(arraytoken) == 0 {
+		if helpers.SessionCookieEnabled() {
+			if cookie, err := r.Cookie(helpers.SessionCookieName()); err == nil {
+				return cookie.Value, true
+			}
+		}
+		return "", false
 	}
 
-	if len(arraytoken) == 0 {
-		return ""
+	authorization := strings.TrimSpace(arraytoken[0])
+	fields := strings.Fields(authorization)
+	switch {
+	case len(fields) == 2 && strings.EqualFold(fields[0], "Bearer"):
+		return fields[1], false
+	case len(fields) == 1 && !strings.EqualFold(fields[0], "Bearer"):
+		return fields[0], false
 	}
+	return "", false
+}
+
+// checkCSRF checks the CSRF token of the state changing requests authenticated by the
+// session cookie, which browsers send along with requests forged by other sites. If
+// it is not valid it responds to the client and returns false.
+func (uc *UserController) checkCSRF(w http.ResponseWriter, r *http.Request, token string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if helpers.CheckCSRFToken(token, r.Header.Get(helpers.CSRFHeader)) {
+		return true
+	}
+	responseData := models.ResponseData{Data: models.Response{Status: http.StatusForbidden,
+		Error:       codes.InvalidCSRFToken,
+		Description: "The CSRF token is invalid"}}
+	uc.responseToClient(w, responseData)
+	return false
+}
+
+// bearerChallenge sets the WWW-Authenticate header described in RFC 6750. A nil err
+// means no token was provided.
+func (uc *UserController) bearerChallenge(w http.ResponseWriter, err error) {
+	challenge := `Bearer realm="SessionManager"`
+	if err != nil {
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description="%s"`, err.Error())
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// authenticate checks the token of the request and returns its user and token. If
+// the token is not valid it responds to the client and returns false.
+func (uc *UserController) authenticate(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	responseData := models.ResponseData{}
+	token, fromCookie := uc.checkTokenHeader(w, r)
+	if token == "" {
+		responseData.Data = models.Response{Status: http.StatusBadRequest,
+			Error:       codes.NoTokenProvided,
+			Description: "No token was provided"}
+		uc.bearerChallenge(w, nil)
+		uc.responseToClient(w, responseData)
+		return "", "", false
+	}
+	if fromCookie && !uc.checkCSRF(w, r, token) {
+		return "", "", false
+	}
+
+	userID, err := uc.userRepo.CheckToken(token, helpers.ClientIP(r), "")
+	if isTokenError(err) {
+		responseData.Data = uc.tokenErrorResponse(w, err)
+		uc.responseToClient(w, responseData)
+		return "", "", false
+	}
+	if err != nil {
+		responseData.Data = models.Response{Status: http.StatusInternalServerError,
+			Error:       codes.DataBaseError,
+			Description: "There was an error with the database"}
+		uc.responseToClient(w, responseData)
+		log.Printf("Error Checking Token: %v", err)
+		return "", "", false
+	}
+	if userID == "" {
+		responseData.Data = uc.tokenErrorResponse(w, helpers.ErrTokenInvalid)
+		uc.responseToClient(w, responseData)
+		return "", "", false
+	}
+	return userID, token, true
+}
 
-	return arraytoken[0]
+func isTokenError(err error) bool {
+	return err == helpers.ErrTokenExpired || err == helpers.ErrTokenInvalid || err == helpers.ErrTokenAudience ||
+		err == repository.ErrSessionExpired
 }
 
-//CheckToken controller function
+// tokenErrorResponse returns the response to the given token error and sets its challenge
+func (uc *UserController) tokenErrorResponse(w http.ResponseWriter, err error) models.Response {
+	if err == repository.ErrSessionExpired {
+		uc.bearerChallenge(w, err)
+		return models.Response{Status: http.StatusUnauthorized,
+			Error:       codes.ExpiredSession,
+			Description: "The session has expired"}
+	}
+	if err == helpers.ErrTokenAudience {
+		uc.bearerChallenge(w, err)
+		return models.Response{Status: http.StatusUnauthorized,
+			Error:       codes.WrongAudience,
+			Description: "The token is not valid for the audience"}
+	}
+	if err == helpers.ErrTokenExpired {
+		uc.bearerChallenge(w, err)
+		return models.Response{Status: http.StatusUnauthorized,
+			Error:       codes.ExpiredToken,
+			Description: "The token has expired"}
+	}
+	uc.bearerChallenge(w, helpers.ErrTokenInvalid)
+	return models.Response{Status: http.StatusNotFound,
+		Error:       codes.InvalidToken,
+		Description: "The token is invalid"}
+}
+
+//CheckToken controller function. The optional audience query parameter checks the token was issued for it.
+//Valid tokens are answered with their roles and claims.
 func (uc *UserController) CheckToken(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	response := models.Response{Error: codes.Unknown}
 	responseData := models.ResponseData{Data: response}
 	log.Printf("/Token/isValid")
-	token := uc.checkTokenHeader(w, r)
+	token, fromCookie := uc.checkTokenHeader(w, r)
 	if token == "" {
 		response = models.Response{Status: http.StatusBadRequest,
 			Error:       codes.NoTokenProvided,
 			Description: "No token was provided"}
 		responseData.Data = response
+		uc.bearerChallenge(w, nil)
+		uc.responseToClient(w, responseData)
+		return
+	}
+	if fromCookie && !uc.checkCSRF(w, r, token) {
+		return
+	}
+	userID, err := uc.userRepo.CheckToken(token, helpers.ClientIP(r), r.URL.Query().Get("audience"))
+	if isTokenError(err) {
+		responseData.Data = uc.tokenErrorResponse(w, err)
 		uc.responseToClient(w, responseData)
 		return
 	}
-	result, err := uc.userRepo.CheckToken(token)
 	if err != nil {
 		response = models.Response{Status: http.StatusInternalServerError,
 			Error:       codes.DataBaseError,
@@ -283,18 +621,31 @@ func (uc *UserController) CheckToken(w http.ResponseWriter, r *http.Request, p h
 		return
 	}
 
-	if result {
+	if userID != "" {
+		// JWTs carry their claims while the claims of opaque tokens are looked up
+		claims, ok := helpers.TokenCustomClaims(token)
+		if !ok {
+			claims, err = uc.userClaims(userID)
+			if err != nil {
+				response = models.Response{Status: http.StatusInternalServerError,
+					Error:       codes.DataBaseError,
+					Description: "There was an error with the database"}
+				responseData.Data = response
+				uc.responseToClient(w, responseData)
+				log.Printf("Failed getting claims of user %v: %v", userID, err)
+				return
+			}
+		}
 		response = models.Response{Status: http.StatusOK,
 			Error:       codes.Ok,
-			Description: ""}
+			Description: "",
+			Roles:       claims.Roles,
+			Claims:      claims.Claims}
 		responseData.Data = response
 		uc.responseToClient(w, responseData)
 		return
 	}
 
-	response = models.Response{Status: http.StatusNotFound,
-		Error:       codes.InvalidToken,
-		Description: "The token is invalid"}
-	responseData.Data = response
+	responseData.Data = uc.tokenErrorResponse(w, helpers.ErrTokenInvalid)
 	uc.responseToClient(w, responseData)
 }
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/44r0n/SessionManager/codes"
 	"github.com/44r0n/SessionManager/helpers"
@@ -26,6 +28,14 @@ type UserRepositoryTest struct {
 	validEmail bool
 	token      string
 	password   string
+	refreshErr error
+	sessions   []models.Session
+	client     models.Client
+	roles      []string
+	// rehashed is the last password hash updated
+	rehashed string
+	// revokedHint is the token_type_hint of the last revoked token
+	revokedHint string
 }
 
 func (usrt *UserRepositoryTest) Register(user models.User) error {
@@ -36,11 +46,28 @@ func (usrt *UserRepositoryTest) GetIDAndPassword(userName string) (string, strin
 	return userName, usrt.password, usrt.err
 }
 
-func (usrt *UserRepositoryTest) DeleteToken(userID, token string) error {
+func (usrt *UserRepositoryTest) GetNameEmailAndPassword(userID string) (string, string, string, error) {
+	return "Bob Smith", "mail@mail.com", usrt.password, usrt.err
+}
+
+func (usrt *UserRepositoryTest) GetRoles(userID string) ([]string, error) {
+	return usrt.roles, usrt.err
+}
+
+func (usrt *UserRepositoryTest) UpdatePassword(userID, oldHash, newHash string) (bool, error) {
+	if oldHash != usrt.password {
+		return false, usrt.err
+	}
+	usrt.rehashed = newHash
+	return true, usrt.err
+}
+
+func (usrt *UserRepositoryTest) DeleteToken(token string) error {
 	return usrt.err
 }
 
-func (usrt *UserRepositoryTest) CreateToken(userID, token string) error {
+func (usrt *UserRepositoryTest) CreateToken(sessionID, userID, token, refreshToken string, client models.Client) error {
+	usrt.client = client
 	return usrt.err
 }
 
@@ -52,12 +79,69 @@ func (usrt *UserRepositoryTest) ExistsEmail(email string) (bool, error) {
 	return usrt.validEmail, usrt.err
 }
 
-func (usrt *UserRepositoryTest) CheckToken(token string) (bool, error) {
-	return usrt.validUser, usrt.err
+func (usrt *UserRepositoryTest) CheckToken(token, ip, audience string) (string, error) {
+	if !usrt.validUser {
+		return "", usrt.err
+	}
+	if audience != "" && audience != usrt.client.Audience {
+		return "", helpers.ErrTokenAudience
+	}
+	return "testID", usrt.err
+}
+
+func (usrt *UserRepositoryTest) GetRefreshTokenSession(refreshToken string) (string, models.Session, error) {
+	if usrt.refreshErr == repository.ErrRefreshTokenInvalid {
+		return "", models.Session{}, usrt.refreshErr
+	}
+	return "testID", models.Session{ID: "session", Client: usrt.client}, usrt.err
+}
+
+func (usrt *UserRepositoryTest) RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error {
+	if usrt.refreshErr != nil {
+		return usrt.refreshErr
+	}
+	return usrt.err
+}
+
+func (usrt *UserRepositoryTest) GetSessions(userID, currentToken string) ([]models.Session, error) {
+	return usrt.sessions, usrt.err
+}
+
+func (usrt *UserRepositoryTest) DeleteSession(userID, sessionID string) (bool, error) {
+	for _, session := range usrt.sessions {
+		if session.ID == sessionID {
+			return true, usrt.err
+		}
+	}
+	return false, usrt.err
+}
+
+func (usrt *UserRepositoryTest) DeleteSessions(userID string) error {
+	return usrt.err
+}
+
+func (usrt *UserRepositoryTest) IntrospectToken(token string) (models.Introspection, error) {
+	if !usrt.validUser {
+		return models.Introspection{Active: false}, usrt.err
+	}
+	now := time.Now()
+	return models.Introspection{Active: true,
+		Sub:       "testID",
+		Iat:       now.Unix(),
+		Exp:       now.Add(time.Hour).Unix(),
+		Scope:     usrt.client.Scope,
+		ClientID:  usrt.client.ClientID,
+		SessionID: "session",
+		TokenType: "Bearer"}, usrt.err
+}
+
+func (usrt *UserRepositoryTest) RevokeToken(token, tokenTypeHint string) error {
+	usrt.revokedHint = tokenTypeHint
+	return usrt.err
 }
 
 func NewUserRepositoryTest(user, email bool, errs error, token, pass string) repository.IUserRepositoryInterface {
-	usrt := UserRepositoryTest{errs, user, email, token, pass}
+	usrt := UserRepositoryTest{err: errs, validUser: user, validEmail: email, token: token, password: pass}
 	return &usrt
 }
 
@@ -363,15 +447,37 @@ func TestLoginOK(t *testing.T) {
 
 		status := rr.Code
 		So(status, ShouldEqual, http.StatusOK)
+		response := models.ResponseData{}
+		err = json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed decoding json: %v", err)
+		}
+		So(response.Data.Token, ShouldNotBeEmpty)
+		So(response.Data.RefreshToken, ShouldNotBeEmpty)
 		if *database {
-			response := models.ResponseData{}
-			err = json.NewDecoder(rr.Body).Decode(&response)
-			if err != nil {
-				t.Fatalf("Failed decoding json: %v", err)
-			}
 			rr := simulateCheckToken(&repo, response.Data.Token, t)
 			status := rr.Code
 			So(status, ShouldEqual, http.StatusOK)
+
+			Convey("The refresh token can be used only once", func() {
+				rr := simulateRefresh(repo, response.Data.RefreshToken, t)
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				refreshed := models.ResponseData{}
+				err = json.NewDecoder(rr.Body).Decode(&refreshed)
+				if err != nil {
+					t.Fatalf("Failed decoding json: %v", err)
+				}
+				So(refreshed.Data.RefreshToken, ShouldNotEqual, response.Data.RefreshToken)
+
+				rr = simulateRefresh(repo, response.Data.RefreshToken, t)
+				So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+				rr = simulateRefresh(repo, refreshed.Data.RefreshToken, t)
+				So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+				rr = simulateCheckToken(&repo, refreshed.Data.Token, t)
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+			})
 		}
 	})
 }
@@ -495,13 +601,13 @@ func TestLogoutOK(t *testing.T) {
 			}
 			repo.Register(user)
 			userID, _, err := repo.GetIDAndPassword(user.UserName)
-			token, err = helpers.Tokenize(userID)
+			token, err = helpers.Tokenize(userID, "session", "", helpers.CustomClaims{})
 			if err != nil {
 				t.Fatal(err)
 			}
 		} else {
 			repo = NewUserRepositoryTest(true, false, nil, "", "")
-			token, err = helpers.Tokenize("testID")
+			token, err = helpers.Tokenize("testID", "session", "", helpers.CustomClaims{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -526,7 +632,7 @@ func simulateLogout(usrt repository.IUserRepositoryInterface, token string, t *t
 	uc := NewUserController(usrt)
 	req, err := http.NewRequest("POST", "/Logout", nil)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", token)
+	req.Header.Set("Authorization", "Bearer "+token)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -554,6 +660,7 @@ func TestLogoutNotOK(t *testing.T) {
 		So(response.Data.Status, ShouldEqual, http.StatusBadRequest)
 		So(response.Data.Error, ShouldEqual, codes.NoTokenProvided)
 		So(response.Data.Description, ShouldEqual, "No token was provided")
+		So(rr.Header().Get("WWW-Authenticate"), ShouldEqual, `Bearer realm="SessionManager"`)
 	})
 }
 
@@ -579,7 +686,7 @@ func simulateCheckToken(usrt *repository.IUserRepositoryInterface, token string,
 	uc := NewUserController(*usrt)
 	req, err := http.NewRequest("POST", "/Token/isValid", nil)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", token)
+	req.Header.Set("Authorization", "Bearer "+token)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -607,5 +714,506 @@ func TestCeckTokenNotOK(t *testing.T) {
 		So(response.Data.Status, ShouldEqual, http.StatusNotFound)
 		So(response.Data.Error, ShouldEqual, codes.InvalidToken)
 		So(response.Data.Description, ShouldEqual, "The token is invalid")
+		So(rr.Header().Get("WWW-Authenticate"), ShouldContainSubstring, `error="invalid_token"`)
+	})
+}
+
+func TestCheckTokenExpired(t *testing.T) {
+	Convey("Given an expired token, it should return unauthorized when it is checked", t, func() {
+		repo := NewUserRepositoryTest(false, false, helpers.ErrTokenExpired, "", "")
+		rr := simulateCheckToken(&repo, "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t)
+		status := rr.Code
+		So(status, ShouldEqual, http.StatusUnauthorized)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Status, ShouldEqual, http.StatusUnauthorized)
+		So(response.Data.Error, ShouldEqual, codes.ExpiredToken)
+		So(response.Data.Description, ShouldEqual, "The token has expired")
+	})
+}
+
+func TestCheckTokenForged(t *testing.T) {
+	Convey("Given a forged token, it should return invalid token when it is checked", t, func() {
+		repo := NewUserRepositoryTest(false, false, helpers.ErrTokenInvalid, "", "")
+		rr := simulateCheckToken(&repo, "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t)
+		status := rr.Code
+		So(status, ShouldEqual, http.StatusNotFound)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Status, ShouldEqual, http.StatusNotFound)
+		So(response.Data.Error, ShouldEqual, codes.InvalidToken)
+		So(response.Data.Description, ShouldEqual, "The token is invalid")
+	})
+}
+
+func simulateRefresh(usrt repository.IUserRepositoryInterface, refreshToken string, t *testing.T) *httptest.ResponseRecorder {
+	uc := NewUserController(usrt)
+	req, err := http.NewRequest("POST", "/Token/refresh", bytes.NewBuffer([]byte(`{"RefreshToken":"`+refreshToken+`"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := httprouter.New()
+
+	router.Handle("POST", "/Token/refresh", uc.RefreshToken)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRefreshTokenOK(t *testing.T) {
+	Convey("Given a valid refresh token, it should return a new token and refresh token", t, func() {
+		rr := simulateRefresh(NewUserRepositoryTest(true, false, nil, "", ""), "refreshToken", t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.Ok)
+		So(response.Data.Token, ShouldNotBeEmpty)
+		So(response.Data.RefreshToken, ShouldNotBeEmpty)
+		So(response.Data.RefreshToken, ShouldNotEqual, "refreshToken")
+	})
+}
+
+func TestRefreshTokenReused(t *testing.T) {
+	Convey("Given an already used refresh token, it should return unauthorized", t, func() {
+		repo := &UserRepositoryTest{refreshErr: repository.ErrRefreshTokenReused}
+		rr := simulateRefresh(repo, "refreshToken", t)
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.InvalidRefreshToken)
+		So(response.Data.Token, ShouldBeEmpty)
+		So(response.Data.RefreshToken, ShouldBeEmpty)
+	})
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	Convey("Given an unknown refresh token, it should return unauthorized", t, func() {
+		repo := &UserRepositoryTest{refreshErr: repository.ErrRefreshTokenInvalid}
+		rr := simulateRefresh(repo, "refreshToken", t)
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.InvalidRefreshToken)
+		So(response.Data.Description, ShouldEqual, "The refresh token is invalid")
+	})
+}
+
+func TestMultipleSessions(t *testing.T) {
+	Convey("Given a user logged in from two devices", t, func() {
+		const pass = "passSessions"
+		var repo repository.IUserRepositoryInterface
+		var err error
+		if *database {
+			repo, err = repository.NewUserRepository(connString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repo.Register(models.User{UserName: "TwoSessions", Email: "twosessions@mail.com", Password: pass})
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			genPass, err := helpers.GenerateHash(pass)
+			if err != nil {
+				t.Fatalf("Failed generating password: %v", err)
+			}
+			repo = NewUserRepositoryTest(true, false, nil, "", genPass)
+		}
+
+		login := func() string {
+			rr := simulateLogin(&repo, []byte(`{"UserName":"TwoSessions","Password":"`+pass+`"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed decoding json: %v", err)
+			}
+			return response.Data.Token
+		}
+		first := login()
+		second := login()
+		So(second, ShouldNotEqual, first)
+
+		Convey("Logging out from one device keeps the other session", func() {
+			rr := simulateLogout(repo, first, t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			if *database {
+				rr = simulateCheckToken(&repo, first, t)
+				So(rr.Code, ShouldEqual, http.StatusNotFound)
+				rr = simulateCheckToken(&repo, second, t)
+				So(rr.Code, ShouldEqual, http.StatusOK)
+			}
+		})
+	})
+}
+
+func TestLoginClient(t *testing.T) {
+	Convey("Given a valid user logging in from a named device, the session should store the client", t, func() {
+		const pass = "passTest"
+		genPass, err := helpers.GenerateHash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: genPass}
+		uc := NewUserController(repo)
+		req, err := http.NewRequest("POST", "/Login", bytes.NewBuffer([]byte(`{"UserName":"LogOK","Password":"`+pass+`","DeviceName":"My phone"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "TestAgent/1.0")
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		req.RemoteAddr = "8.8.8.8:1234"
+
+		rr := httptest.NewRecorder()
+		router := httprouter.New()
+
+		router.Handle("POST", "/Login", uc.Login)
+		router.ServeHTTP(rr, req)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(repo.client.DeviceName, ShouldEqual, "My phone")
+		So(repo.client.UserAgent, ShouldEqual, "TestAgent/1.0")
+		So(repo.client.IP, ShouldEqual, "8.8.8.8")
+	})
+}
+
+func TestCheckTokenExpiredSession(t *testing.T) {
+	Convey("Given the token of an expired session, it should return unauthorized when it is checked", t, func() {
+		repo := NewUserRepositoryTest(false, false, repository.ErrSessionExpired, "", "")
+		rr := simulateCheckToken(&repo, "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t)
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+		response := models.ResponseData{}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Status, ShouldEqual, http.StatusUnauthorized)
+		So(response.Data.Error, ShouldEqual, codes.ExpiredSession)
+		So(response.Data.Description, ShouldEqual, "The session has expired")
+	})
+}
+
+func TestCheckTokenHeader(t *testing.T) {
+	Convey("Given the Authorization header, the token should be taken from it", t, func() {
+		uc := NewUserController(NewUserRepositoryTest(true, false, nil, "", ""))
+		cases := map[string]string{
+			"Bearer 1234abcd":    "1234abcd",
+			"bearer  1234abcd ":  "1234abcd",
+			"1234abcd":           "1234abcd",
+			"Bearer":             "",
+			"Basic dXNlcjpwYXNz": "",
+			"Bearer 1234 abcd":   "",
+		}
+		for header, expected := range cases {
+			req, err := http.NewRequest("POST", "/Token/isValid", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", header)
+			token, fromCookie := uc.checkTokenHeader(httptest.NewRecorder(), req)
+			So(token, ShouldEqual, expected)
+			So(fromCookie, ShouldBeFalse)
+		}
+	})
+}
+
+func TestCheckTokenAudience(t *testing.T) {
+	Convey("Given a token issued for an audience", t, func() {
+		repo := &UserRepositoryTest{validUser: true, client: models.Client{Audience: "api"}}
+		uc := NewUserController(repo)
+		router := httprouter.New()
+		router.Handle("POST", "/Token/isValid", uc.CheckToken)
+		check := func(url string) *httptest.ResponseRecorder {
+			req, err := http.NewRequest("POST", url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer 1234abcd")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		Convey("It should be valid for its audience and without one", func() {
+			So(check("/Token/isValid?audience=api").Code, ShouldEqual, http.StatusOK)
+			So(check("/Token/isValid").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("It should be unauthorized for other audiences", func() {
+			rr := check("/Token/isValid?audience=admin")
+			So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.WrongAudience)
+			So(rr.Header().Get("WWW-Authenticate"), ShouldContainSubstring, `error="invalid_token"`)
+		})
+	})
+}
+
+func TestLoginAudience(t *testing.T) {
+	Convey("Given a login for an audience, the token should be issued for it", t, func() {
+		const pass = "passTest"
+		genPass, err := helpers.GenerateHash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: genPass}
+		var userRepo repository.IUserRepositoryInterface = repo
+		rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`","Audience":"api"}`), t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(repo.client.Audience, ShouldEqual, "api")
+
+		response := models.ResponseData{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed decoding json: %v", err)
+		}
+		claims, err := helpers.GetClaimsFromToken(response.Data.Token)
+		So(err, ShouldBeNil)
+		So(claims.Audience, ShouldEqual, "api")
+		So(claims.Issuer, ShouldEqual, helpers.Issuer())
+	})
+}
+
+func TestLoginClientScope(t *testing.T) {
+	Convey("Given a login for a client", t, func() {
+		helpers.SetClients([]helpers.ClientConfiguration{{ID: "webapp", Scopes: []string{"read", "write"}}})
+		defer helpers.SetClients(nil)
+		const pass = "passTest"
+		genPass, err := helpers.GenerateHash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: genPass}
+		var userRepo repository.IUserRepositoryInterface = repo
+
+		Convey("Its session should be opened with the allowed scope", func() {
+			rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`","ClientID":"webapp","Scope":"read write"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(repo.client.ClientID, ShouldEqual, "webapp")
+			So(repo.client.Scope, ShouldEqual, "read write")
+		})
+
+		Convey("Unknown clients and scopes should be rejected", func() {
+			for _, login := range []string{`"ClientID":"webapp","Scope":"read admin"`, `"ClientID":"another"`, `"Scope":"read"`} {
+				rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`",`+login+`}`), t)
+				So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+				response := models.ResponseData{}
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatalf("Failed decoding json: %v", err)
+				}
+				So(response.Data.Error, ShouldEqual, codes.WrongClient)
+			}
+			So(repo.client, ShouldResemble, models.Client{})
+		})
+	})
+}
+
+func TestTokenClaims(t *testing.T) {
+	Convey("Given a user with roles and an enricher, its tokens should carry their claims", t, func() {
+		helpers.SetClaimsEnrichers(func(userID string, claims *helpers.CustomClaims) error {
+			if userID == "ClaimsUser" {
+				claims.Claims = map[string]interface{}{"tenant": "acme"}
+			}
+			return nil
+		})
+		defer helpers.SetClaimsEnrichers()
+		const pass = "passTest"
+		genPass, err := helpers.GenerateHash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		var repo repository.IUserRepositoryInterface = &UserRepositoryTest{validUser: true, password: genPass, roles: []string{"admin", "editor"}}
+		rr := simulateLogin(&repo, []byte(`{"UserName":"ClaimsUser","Password":"`+pass+`"}`), t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		response := models.ResponseData{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed decoding json: %v", err)
+		}
+
+		rr = simulateCheckToken(&repo, response.Data.Token, t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		checked := models.ResponseData{}
+		if err := json.NewDecoder(rr.Body).Decode(&checked); err != nil {
+			t.Fatalf("Failed decoding json: %v", err)
+		}
+		So(checked.Data.Roles, ShouldResemble, []string{"admin", "editor"})
+		So(checked.Data.Claims["tenant"], ShouldEqual, "acme")
+
+		Convey("Opaque tokens should be answered with the claims of the user", func() {
+			rr := simulateCheckToken(&repo, "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			checked := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&checked); err != nil {
+				t.Fatalf("Failed decoding json: %v", err)
+			}
+			So(checked.Data.Roles, ShouldResemble, []string{"admin", "editor"})
+		})
+	})
+}
+
+func TestTokenGenerationError(t *testing.T) {
+	Convey("Given claims that cannot be signed, logins and refreshes should fail", t, func() {
+		helpers.SetClaimsEnrichers(func(userID string, claims *helpers.CustomClaims) error {
+			claims.Claims = map[string]interface{}{"unsigned": make(chan int)}
+			return nil
+		})
+		defer helpers.SetClaimsEnrichers()
+		const pass = "passTest"
+		genPass, err := helpers.GenerateHash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		var repo repository.IUserRepositoryInterface = &UserRepositoryTest{validUser: true, password: genPass}
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			simulateLogin(&repo, []byte(`{"UserName":"LogOK","Password":"`+pass+`"}`), t),
+			simulateRefresh(repo, "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", t),
+		} {
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed decoding json: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.Unknown)
+			So(response.Data.Token, ShouldBeEmpty)
+		}
+	})
+}
+
+func TestLoginRehash(t *testing.T) {
+	Convey("Given a password hashed below the current policy, it should be rehashed on login", t, func() {
+		const pass = "passTest"
+		weak, err := helpers.BcryptHasher{Cost: 4}.Hash(pass)
+		if err != nil {
+			t.Fatalf("Failed generating password: %v", err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: weak}
+		var userRepo repository.IUserRepositoryInterface = repo
+		rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`"}`), t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(repo.rehashed, ShouldNotBeEmpty)
+		So(helpers.NeedsRehash(repo.rehashed), ShouldBeFalse)
+		So(helpers.CheckHash(repo.rehashed, pass), ShouldBeNil)
+
+		Convey("A current hash should be kept", func() {
+			repo := &UserRepositoryTest{validUser: true, password: repo.rehashed}
+			var userRepo repository.IUserRepositoryInterface = repo
+			rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+
+		Convey("A wrong password should not be rehashed", func() {
+			repo := &UserRepositoryTest{validUser: true, password: weak}
+			var userRepo repository.IUserRepositoryInterface = repo
+			rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"wrong"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+
+		Convey("A rejected login should not be rehashed", func() {
+			repo := &UserRepositoryTest{validUser: true, password: weak}
+			var userRepo repository.IUserRepositoryInterface = repo
+			rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"`+pass+`","ClientID":"unknown"}`), t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(repo.rehashed, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestLoginLegacyHash(t *testing.T) {
+	Convey("Given a user imported with a legacy hash, it should log in and get a current hash", t, func() {
+		legacy, err := helpers.TagLegacyHash(helpers.LegacyMD5Crypt, "$1$saltstri$qQY4WxjABChYG1ccLpfkz/", "")
+		if err != nil {
+			t.Fatalf("Failed tagging password: %v", err)
+		}
+		repo := &UserRepositoryTest{validUser: true, password: legacy}
+		var userRepo repository.IUserRepositoryInterface = repo
+		rr := simulateLogin(&userRepo, []byte(`{"UserName":"LogOK","Password":"password"}`), t)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(repo.rehashed, ShouldStartWith, "$argon2id$")
+		So(helpers.CheckHash(repo.rehashed, "password"), ShouldBeNil)
+	})
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	Convey("Given a password that does not meet the policy, it should not be registered", t, func() {
+		rr := registerUser([]byte(`{"UserName":"Bob Smith","Email":"mail@mail.com","Password":"bob smith"}`),
+			NewUserRepositoryTest(false, false, nil, "", ""), t)
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+		response := models.ResponseData{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed unmarshaling response: %v", err)
+		}
+		So(response.Data.Error, ShouldEqual, codes.InvalidPassword)
+		So(response.Data.Fields["Password"], ShouldResemble, []string{"The password must not contain the user name"})
+	})
+}
+
+type breachedPasswordsTest struct {
+	err error
+}
+
+func (bpt breachedPasswordsTest) Contains(sum [sha1.Size]byte) (bool, error) {
+	return sum == sha1.Sum([]byte("breachedPassword")), bpt.err
+}
+
+func TestRegisterBreachedPassword(t *testing.T) {
+	Convey("Given a dataset of breached passwords", t, func() {
+		helpers.SetBreachedPasswords(breachedPasswordsTest{})
+		defer helpers.SetBreachedPasswords(nil)
+
+		Convey("A breached password should not be registered", func() {
+			rr := registerUser([]byte(`{"UserName":"Bob Smith","Email":"mail@mail.com","Password":"breachedPassword"}`),
+				NewUserRepositoryTest(false, false, nil, "", ""), t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+			response := models.ResponseData{}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed unmarshaling response: %v", err)
+			}
+			So(response.Data.Error, ShouldEqual, codes.InvalidPassword)
+			So(response.Data.Fields["Password"], ShouldResemble, []string{"The password has appeared in a data breach"})
+		})
+
+		Convey("Other passwords should be registered", func() {
+			rr := registerUser([]byte(`{"UserName":"Bob Smith","Email":"mail@mail.com","Password":"secretPassword"}`),
+				NewUserRepositoryTest(false, false, nil, "", ""), t)
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("A failing dataset should not register the user", func() {
+			helpers.SetBreachedPasswords(breachedPasswordsTest{err: errors.New("missing range file")})
+			rr := registerUser([]byte(`{"UserName":"Bob Smith","Email":"mail@mail.com","Password":"secretPassword"}`),
+				NewUserRepositoryTest(false, false, nil, "", ""), t)
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+		})
 	})
 }
@@ -0,0 +1,36 @@
+-- The schema before the migrations of data/migrations, to test upgrading it.
+DROP SCHEMA IF EXISTS sessionmanager;
+CREATE SCHEMA sessionmanager;
+USE sessionmanager;
+
+DROP TABLE IF EXISTS configuration;
+CREATE TABLE configuration (
+  name VARCHAR(165) NOT NULL,
+  value VARCHAR(165) NOT NULL,
+  PRIMARY KEY (name)
+);
+
+DROP TABLE IF EXISTS users;
+CREATE TABLE users (
+  id CHAR(36)  NOT NULL ,
+  username VARCHAR(165) UNIQUE NOT NULL,
+  email VARCHAR(165) UNIQUE NOT NULL,
+  password VARCHAR(128) NOT NULL,
+  status TINYINT DEFAULT 1,
+  date_created DATETIME NOT NULL,
+  PRIMARY KEY (id),
+  FULLTEXT (username,password)
+);
+
+DROP TABLE IF EXISTS user_tokens;
+CREATE TABLE user_tokens (
+  user CHAR(36) NOT NULL,
+  token VARCHAR(256) NOT NULL,
+  last_date_used DATETIME NOT NULL,
+  PRIMARY KEY (user),
+  FOREIGN KEY (user) REFERENCES users(id)
+);
+
+-- A user logged in before upgrading, so the migrations also convert existing rows.
+INSERT INTO users (id, username, email, password, date_created) VALUES ('00000000-0000-0000-0000-000000000001', 'baseline', 'baseline@mail.com', '$2a$10$baselinebaselinebaselinebaselinebaselinebaselinebasel', NOW());
+INSERT INTO user_tokens (user, token, last_date_used) VALUES ('00000000-0000-0000-0000-000000000001', 'a token issued before upgrading', NOW());
@@ -56,6 +56,20 @@ func (datab *Database) ExecuteNonQuery(query string, args ...interface{}) error
 	return nil
 }
 
+// ExecuteNonQueryCount executes non query and returns the number of affected rows
+func (datab *Database) ExecuteNonQueryCount(query string, args ...interface{}) (int64, error) {
+	if e := datab.Connect(); e != nil {
+		return 0, e
+	}
+	defer datab.Close()
+	result, err := datab.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // ExecuteQuery executes the query and returs the obtained rows.
 func (datab *Database) ExecuteQuery(query string, args ...interface{}) (*sql.Rows,error) {
 	if e := datab.Connect(); e != nil {
@@ -65,6 +79,26 @@ func (datab *Database) ExecuteQuery(query string, args ...interface{}) (*sql.Row
 	return datab.db.Query(query, args...)
 }
 
+// ExecuteInTransaction executes the given function inside a transaction. The
+// transaction is committed if the function returns no error.
+func (datab *Database) ExecuteInTransaction(fn func(tx *sql.Tx) error) error {
+	if e := datab.Connect(); e != nil {
+		return e
+	}
+	defer datab.Close()
+	tx, err := datab.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // Close function
 func (datab *Database) Close() {
 	if datab.db != nil {
@@ -0,0 +1,20 @@
+-- Adds the refresh tokens, grouped in a family per session so a reused one revokes them all.
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN family CHAR(36) NULL AFTER token;
+UPDATE user_tokens SET family = uuid();
+ALTER TABLE user_tokens
+  MODIFY family CHAR(36) NOT NULL,
+  ADD UNIQUE (family);
+
+CREATE TABLE refresh_tokens (
+  token VARCHAR(64) NOT NULL,
+  user CHAR(36) NOT NULL,
+  family CHAR(36) NOT NULL,
+  used TINYINT NOT NULL DEFAULT 0,
+  date_created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  PRIMARY KEY (token),
+  INDEX (family),
+  FOREIGN KEY (user) REFERENCES users(id)
+);
@@ -0,0 +1,23 @@
+-- Allows several sessions per user. The family of the refresh tokens of every session
+-- becomes its id, so the existing sessions keep their refresh tokens.
+USE sessionmanager;
+
+ALTER TABLE user_tokens
+  ADD COLUMN id CHAR(36) NULL FIRST,
+  ADD COLUMN date_created DATETIME NULL AFTER token;
+UPDATE user_tokens SET id = family, date_created = last_date_used;
+ALTER TABLE user_tokens ADD INDEX (user);
+ALTER TABLE user_tokens
+  DROP PRIMARY KEY,
+  MODIFY id CHAR(36) NOT NULL,
+  MODIFY date_created DATETIME NOT NULL,
+  ADD PRIMARY KEY (id),
+  DROP INDEX family,
+  DROP COLUMN family,
+  ADD INDEX (token(64));
+
+DELETE FROM refresh_tokens WHERE family NOT IN (SELECT id FROM user_tokens);
+ALTER TABLE refresh_tokens
+  DROP INDEX family,
+  CHANGE family session CHAR(36) NOT NULL,
+  ADD FOREIGN KEY (session) REFERENCES user_tokens(id) ON DELETE CASCADE;
@@ -0,0 +1,8 @@
+-- Adds the client ip, user agent and device name of every session.
+USE sessionmanager;
+
+ALTER TABLE user_tokens
+  ADD COLUMN ip VARCHAR(45) AFTER token,
+  ADD COLUMN user_agent VARCHAR(255) AFTER ip,
+  ADD COLUMN device_name VARCHAR(165) AFTER user_agent,
+  ADD COLUMN last_ip VARCHAR(45) AFTER device_name;
@@ -0,0 +1,15 @@
+-- Replaces the raw tokens of user_tokens and refresh_tokens by their SHA-256.
+-- Existing sessions keep working as long as TokenHashKey is not configured. If it
+-- is, delete the existing sessions instead: DELETE FROM user_tokens;
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN token_hash CHAR(64) NULL AFTER user;
+UPDATE user_tokens SET token_hash = SHA2(token, 256);
+ALTER TABLE user_tokens
+  DROP INDEX token,
+  DROP COLUMN token,
+  MODIFY token_hash CHAR(64) NOT NULL,
+  ADD INDEX (token_hash);
+
+UPDATE refresh_tokens SET token = SHA2(token, 256);
+ALTER TABLE refresh_tokens CHANGE token token_hash CHAR(64) NOT NULL;
@@ -0,0 +1,6 @@
+-- Adds the expiration date of the current token of every session, needed by opaque tokens.
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN token_expires DATETIME NULL AFTER token_hash;
+UPDATE user_tokens SET token_expires = DATE_ADD(last_date_used, INTERVAL 1 HOUR);
+ALTER TABLE user_tokens MODIFY token_expires DATETIME NOT NULL;
@@ -0,0 +1,8 @@
+-- Adds the issue date of the current token and the client and scope of every session, needed by token introspection.
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN token_issued DATETIME NULL AFTER token_hash;
+UPDATE user_tokens SET token_issued = DATE_SUB(token_expires, INTERVAL 1 HOUR);
+ALTER TABLE user_tokens MODIFY token_issued DATETIME NOT NULL;
+ALTER TABLE user_tokens ADD COLUMN client_id VARCHAR(165) AFTER last_ip;
+ALTER TABLE user_tokens ADD COLUMN scope VARCHAR(255) AFTER client_id;
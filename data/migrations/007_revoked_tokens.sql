@@ -0,0 +1,12 @@
+-- Adds the jti of the current token of every session and the denylist of revoked tokens, needed by the stateless verification mode.
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN token_id VARCHAR(64) AFTER token_hash;
+
+CREATE TABLE revoked_tokens (
+  jti VARCHAR(64) NOT NULL,
+  expires DATETIME NOT NULL,
+  date_revoked DATETIME NOT NULL,
+  PRIMARY KEY (jti),
+  INDEX (date_revoked)
+);
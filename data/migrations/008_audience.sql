@@ -0,0 +1,4 @@
+-- Adds the audience of every session, checked along with the aud claim of its tokens.
+USE sessionmanager;
+
+ALTER TABLE user_tokens ADD COLUMN audience VARCHAR(165) AFTER scope;
@@ -0,0 +1,9 @@
+-- Adds the roles of the users, embedded in their tokens.
+USE sessionmanager;
+
+CREATE TABLE user_roles (
+  user CHAR(36) NOT NULL,
+  role VARCHAR(165) NOT NULL,
+  PRIMARY KEY (user, role),
+  FOREIGN KEY (user) REFERENCES users(id) ON DELETE CASCADE
+);
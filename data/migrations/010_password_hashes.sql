@@ -0,0 +1,4 @@
+-- Widens the password hashes, stored as PHC strings of any supported algorithm.
+USE sessionmanager;
+
+ALTER TABLE users MODIFY password VARCHAR(255) NOT NULL;
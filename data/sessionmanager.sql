@@ -15,18 +15,62 @@ CREATE TABLE users (
   id CHAR(36)  NOT NULL ,
   username VARCHAR(165) UNIQUE NOT NULL,
   email VARCHAR(165) UNIQUE NOT NULL,
-  password VARCHAR(128) NOT NULL,
+  password VARCHAR(255) NOT NULL,
   status TINYINT DEFAULT 1,
   date_created DATETIME NOT NULL,
   PRIMARY KEY (id),
   FULLTEXT (username,password)
 );
 
+DROP TABLE IF EXISTS user_roles;
+CREATE TABLE user_roles (
+  user CHAR(36) NOT NULL,
+  role VARCHAR(165) NOT NULL,
+  PRIMARY KEY (user, role),
+  FOREIGN KEY (user) REFERENCES users(id) ON DELETE CASCADE
+);
+
 DROP TABLE IF EXISTS user_tokens;
 CREATE TABLE user_tokens (
+  id CHAR(36) NOT NULL,
   user CHAR(36) NOT NULL,
-  token VARCHAR(256) NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  token_id VARCHAR(64),
+  token_issued DATETIME NOT NULL,
+  token_expires DATETIME NOT NULL,
+  ip VARCHAR(45),
+  user_agent VARCHAR(255),
+  device_name VARCHAR(165),
+  last_ip VARCHAR(45),
+  client_id VARCHAR(165),
+  scope VARCHAR(255),
+  audience VARCHAR(165),
+  date_created DATETIME NOT NULL,
   last_date_used DATETIME NOT NULL,
-  PRIMARY KEY (user),
+  PRIMARY KEY (id),
+  INDEX (user),
+  INDEX (token_hash),
   FOREIGN KEY (user) REFERENCES users(id)
 );
+
+DROP TABLE IF EXISTS refresh_tokens;
+CREATE TABLE refresh_tokens (
+  token_hash CHAR(64) NOT NULL,
+  user CHAR(36) NOT NULL,
+  session CHAR(36) NOT NULL,
+  used TINYINT NOT NULL DEFAULT 0,
+  date_created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  PRIMARY KEY (token_hash),
+  FOREIGN KEY (user) REFERENCES users(id),
+  FOREIGN KEY (session) REFERENCES user_tokens(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS revoked_tokens;
+CREATE TABLE revoked_tokens (
+  jti VARCHAR(64) NOT NULL,
+  expires DATETIME NOT NULL,
+  date_revoked DATETIME NOT NULL,
+  PRIMARY KEY (jti),
+  INDEX (date_revoked)
+);
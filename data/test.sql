@@ -1,12 +1,31 @@
 USE sessionmanager;
 BEGIN;
-SELECT tap.plan(7);
+SELECT tap.plan(26);
 SELECT tap.has_table(DATABASE(),'users','Check users table');
 SELECT tap.has_column(DATABASE(),'users','username','Check user name in users');
 SELECT tap.has_column(DATABASE(),'users','password','Check the password in users');
 SELECT tap.has_column(DATABASE(),'users','email','Check the mail in users');
 SELECT tap.has_table(DATABASE(),'user_tokens','Check user_tokens table');
 SELECT tap.has_column(DATABASE(),'user_tokens','user','Check the user in user_tokens');
-SELECT tap.has_column(DATABASE(),'user_tokens','token','Check the token in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','token_hash','Check the token hash in user_tokens');
+SELECT tap.hasnt_column(DATABASE(),'user_tokens','token','Check the raw token is not stored in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','id','Check the session id in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','token_expires','Check the token expiration in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','token_issued','Check the token issue date in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','client_id','Check the client id in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','scope','Check the scope in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','audience','Check the audience in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','ip','Check the ip in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','user_agent','Check the user agent in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','device_name','Check the device name in user_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','last_ip','Check the last ip in user_tokens');
+SELECT tap.has_table(DATABASE(),'refresh_tokens','Check refresh_tokens table');
+SELECT tap.has_column(DATABASE(),'refresh_tokens','session','Check the session in refresh_tokens');
+SELECT tap.has_column(DATABASE(),'refresh_tokens','used','Check the used flag in refresh_tokens');
+SELECT tap.has_column(DATABASE(),'user_tokens','token_id','Check the token id in user_tokens');
+SELECT tap.has_table(DATABASE(),'revoked_tokens','Check revoked_tokens table');
+SELECT tap.has_column(DATABASE(),'revoked_tokens','jti','Check the token id in revoked_tokens');
+SELECT tap.has_table(DATABASE(),'user_roles','Check user_roles table');
+SELECT tap.has_column(DATABASE(),'user_roles','role','Check the role in user_roles');
 CALL tap.finish();
 ROLLBACK;
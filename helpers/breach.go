@@ -0,0 +1,295 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// bloomFilterMagic starts the files of the bloom filters of breached passwords
+	bloomFilterMagic = "SMBF"
+	// bloomFilterHeaderSize is the size of the magic, the number of hashes and of bits
+	bloomFilterHeaderSize = len(bloomFilterMagic) + 4 + 8
+	// maxBloomFilterHashes is more hashes than any useful false positive rate needs
+	maxBloomFilterHashes = 64
+)
+
+// ErrBloomFilterInvalid is returned when a bloom filter file is malformed
+var ErrBloomFilterInvalid = errors.New("The bloom filter is invalid")
+
+// BreachedPasswords is a dataset of the SHA-1 of the passwords that appeared in known breaches
+type BreachedPasswords interface {
+	// Contains checks if the given SHA-1 is in the dataset
+	Contains(sum [sha1.Size]byte) (bool, error)
+}
+
+var (
+	breachedPasswords     BreachedPasswords
+	breachedPasswordsLock sync.RWMutex
+)
+
+// SetBreachedPasswords sets the dataset new passwords are checked against, nil to disable the check
+func SetBreachedPasswords(dataset BreachedPasswords) {
+	breachedPasswordsLock.Lock()
+	defer breachedPasswordsLock.Unlock()
+	breachedPasswords = dataset
+}
+
+// InitBreachedPasswords loads the configured dataset of breached passwords from a given
+// json formated file. The check is disabled if none is configured.
+func InitBreachedPasswords(configFileName string) error {
+	loadConfig(configFileName)
+	config := configuration.BreachedPasswords
+	switch {
+	case config.BloomFilter != "":
+		f, err := os.Open(config.BloomFilter)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		filter, err := ReadBloomFilter(bufio.NewReader(f), info.Size())
+		if err != nil {
+			return fmt.Errorf("failed reading bloom filter %s: %v", config.BloomFilter, err)
+		}
+		SetBreachedPasswords(filter)
+	case config.RangeDirectory != "":
+		info, err := os.Stat(config.RangeDirectory)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", config.RangeDirectory)
+		}
+		SetBreachedPasswords(RangeDirectory(config.RangeDirectory))
+	default:
+		SetBreachedPasswords(nil)
+	}
+	return nil
+}
+
+// PasswordBreached checks if the given password appears in the dataset of breached passwords.
+// It is always false if there is no dataset.
+func PasswordBreached(password string) (bool, error) {
+	breachedPasswordsLock.RLock()
+	dataset := breachedPasswords
+	breachedPasswordsLock.RUnlock()
+	if dataset == nil {
+		return false, nil
+	}
+	return dataset.Contains(sha1.Sum([]byte(password)))
+}
+
+// CheckNewPassword returns the rules broken by a new password of the user with the given
+// name and email, the ones of the PasswordPolicy and not appearing in known breaches.
+// Every flow setting a password must check it.
+func CheckNewPassword(password, userName, email string) ([]string, error) {
+	violations := PasswordPolicy(password, userName, email)
+	breached, err := PasswordBreached(password)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		violations = append(violations, "The password has appeared in a data breach")
+	}
+	return violations, nil
+}
+
+// RangeDirectory is a directory of Have I Been Pwned range files, named by the first 5 hex
+// characters of the SHA-1 of the passwords and with one SUFFIX:COUNT line per password
+type RangeDirectory string
+
+// Contains checks if the given SHA-1 is in the range file of its prefix. A missing range
+// file is an error, as the password cannot be checked.
+func (rd RangeDirectory) Contains(sum [sha1.Size]byte) (bool, error) {
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	f, err := rd.open(hash[:5])
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		suffix := strings.SplitN(scanner.Text(), ":", 2)[0]
+		if strings.EqualFold(strings.TrimSpace(suffix), hash[5:]) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (rd RangeDirectory) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(string(rd), prefix))
+	if os.IsNotExist(err) {
+		return os.Open(filepath.Join(string(rd), prefix+".txt"))
+	}
+	return f, err
+}
+
+// ForEachBreachedHash calls fn with the SHA-1 of every password of the given Have I Been
+// Pwned dataset, either a RangeDirectory or a single file of HASH:COUNT lines
+func ForEachBreachedHash(path string, fn func(sum [sha1.Size]byte) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return forEachHashLine(path, "", fn)
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		prefix := strings.TrimSuffix(file.Name(), ".txt")
+		if file.IsDir() || len(prefix) != 5 {
+			continue
+		}
+		if _, err := hex.DecodeString(prefix + "0"); err != nil {
+			continue
+		}
+		if err := forEachHashLine(filepath.Join(path, file.Name()), prefix, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachHashLine(path, prefix string, fn func(sum [sha1.Size]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash := prefix + strings.TrimSpace(strings.SplitN(scanner.Text(), ":", 2)[0])
+		if hash == prefix {
+			continue
+		}
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha1.Size {
+			return fmt.Errorf("%s:%d: invalid SHA-1 %s", path, line, hash)
+		}
+		var sum [sha1.Size]byte
+		copy(sum[:], decoded)
+		if err := fn(sum); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// BloomFilter is a compact BreachedPasswords that can answer that a password is breached
+// when it is not, with the false positive rate it was built for, but never the opposite
+type BloomFilter struct {
+	hashes uint32
+	bits   uint64
+	set    []byte
+}
+
+// NewBloomFilter creates an empty BloomFilter sized for the given number of passwords and false positive rate
+func NewBloomFilter(passwords uint64, falsePositiveRate float64) *BloomFilter {
+	if passwords == 0 {
+		passwords = 1
+	}
+	bits := uint64(math.Ceil(-float64(passwords) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bits < 8 {
+		bits = 8
+	}
+	hashes := uint32(math.Round(float64(bits) / float64(passwords) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	} else if hashes > maxBloomFilterHashes {
+		hashes = maxBloomFilterHashes
+	}
+	return &BloomFilter{hashes: hashes, bits: bits, set: make([]byte, (bits+7)/8)}
+}
+
+// Add adds the given SHA-1 to the filter
+func (bf *BloomFilter) Add(sum [sha1.Size]byte) {
+	bf.each(sum, func(bit uint64) bool {
+		bf.set[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// Contains checks if the given SHA-1 may have been added to the filter
+func (bf *BloomFilter) Contains(sum [sha1.Size]byte) (bool, error) {
+	contains := true
+	bf.each(sum, func(bit uint64) bool {
+		contains = bf.set[bit/8]&(1<<(bit%8)) != 0
+		return contains
+	})
+	return contains, nil
+}
+
+// each calls fn with the bits of the given SHA-1 until it returns false. The SHA-1 is
+// already uniform, so its first 16 bytes are the two hashes of the double hashing.
+func (bf *BloomFilter) each(sum [sha1.Size]byte, fn func(bit uint64) bool) {
+	first := binary.BigEndian.Uint64(sum[0:8])
+	second := binary.BigEndian.Uint64(sum[8:16]) | 1
+	for i := uint64(0); i < uint64(bf.hashes); i++ {
+		if !fn((first + i*second) % bf.bits) {
+			return
+		}
+	}
+}
+
+// WriteTo writes the filter in the format read by ReadBloomFilter
+func (bf *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := new(bytes.Buffer)
+	header.WriteString(bloomFilterMagic)
+	binary.Write(header, binary.BigEndian, bf.hashes)
+	binary.Write(header, binary.BigEndian, bf.bits)
+	written, err := w.Write(header.Bytes())
+	if err != nil {
+		return int64(written), err
+	}
+	n, err := w.Write(bf.set)
+	return int64(written + n), err
+}
+
+// ReadBloomFilter reads a filter written by BloomFilter.WriteTo, size bytes long. Filters
+// whose header does not match their size are rejected before allocating them.
+func ReadBloomFilter(r io.Reader, size int64) (*BloomFilter, error) {
+	magic := make([]byte, len(bloomFilterMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != bloomFilterMagic {
+		return nil, ErrBloomFilterInvalid
+	}
+	bf := new(BloomFilter)
+	if err := binary.Read(r, binary.BigEndian, &bf.hashes); err != nil {
+		return nil, ErrBloomFilterInvalid
+	}
+	if err := binary.Read(r, binary.BigEndian, &bf.bits); err != nil {
+		return nil, ErrBloomFilterInvalid
+	}
+	if bf.hashes == 0 || bf.hashes > maxBloomFilterHashes || bf.bits == 0 {
+		return nil, ErrBloomFilterInvalid
+	}
+	if size < int64(bloomFilterHeaderSize) || uint64(size-int64(bloomFilterHeaderSize)) != (bf.bits+7)/8 {
+		return nil, ErrBloomFilterInvalid
+	}
+	bf.set = make([]byte, (bf.bits+7)/8)
+	if _, err := io.ReadFull(r, bf.set); err != nil {
+		return nil, ErrBloomFilterInvalid
+	}
+	return bf, nil
+}
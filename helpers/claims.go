@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"sync"
+)
+
+// CustomClaims are the roles of a user and any other claims embedded in its tokens, so
+// other services know what the user may do without asking the server again
+type CustomClaims struct {
+	Roles  []string               `json:"roles,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// ClaimsEnricher adds claims of the given user to the claims of its new tokens
+type ClaimsEnricher func(userID string, claims *CustomClaims) error
+
+var (
+	claimsEnrichers     []ClaimsEnricher
+	claimsEnrichersLock sync.RWMutex
+)
+
+// AddClaimsEnricher adds an enricher run every time a token is issued, after the ones added before
+func AddClaimsEnricher(enricher ClaimsEnricher) {
+	claimsEnrichersLock.Lock()
+	defer claimsEnrichersLock.Unlock()
+	claimsEnrichers = append(claimsEnrichers, enricher)
+}
+
+// SetClaimsEnrichers replaces the enrichers by the given ones, none to remove them all
+func SetClaimsEnrichers(enrichers ...ClaimsEnricher) {
+	claimsEnrichersLock.Lock()
+	defer claimsEnrichersLock.Unlock()
+	claimsEnrichers = enrichers
+}
+
+// EnrichClaims runs the enrichers on the claims of the given user, stopping at the first one failing
+func EnrichClaims(userID string, claims *CustomClaims) error {
+	claimsEnrichersLock.RLock()
+	enrichers := claimsEnrichers
+	claimsEnrichersLock.RUnlock()
+	for _, enricher := range enrichers {
+		if err := enricher(userID, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
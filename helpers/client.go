@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// ClientAuthenticationRequired checks if the OAuth endpoints can only be called with the
+// credentials of the configured clients. Without clients they refuse every call, unless
+// OpenOAuthEndpoints is set to let anyone call them.
+func ClientAuthenticationRequired() bool {
+	return len(configuration.Clients) > 0 || !configuration.OpenOAuthEndpoints
+}
+
+// SetClients replaces the configured clients
+func SetClients(clients []ClientConfiguration) {
+	configuration.Clients = clients
+}
+
+// AuthenticateClient checks the given client credentials against the configured clients
+func AuthenticateClient(id, secret string) bool {
+	for _, client := range configuration.Clients {
+		if client.ID == "" || client.Secret == "" {
+			continue
+		}
+		idMatches := subtle.ConstantTimeCompare([]byte(client.ID), []byte(id)) == 1
+		secretMatches := subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) == 1
+		if idMatches && secretMatches {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientScopeAllowed checks if a session can be opened for the given client and scope, a
+// space separated list. The client must be configured and every scope one of its Scopes.
+// Sessions without a client cannot have a scope.
+func ClientScopeAllowed(clientID, scope string) bool {
+	if clientID == "" {
+		return strings.TrimSpace(scope) == ""
+	}
+	for _, client := range configuration.Clients {
+		if client.ID != clientID {
+			continue
+		}
+		for _, requested := range strings.Fields(scope) {
+			if !contains(client.Scopes, requested) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
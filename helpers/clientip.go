@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the ip of the client of the given request. X-Forwarded-For is
+// only honoured when the request comes from one of the configured TrustedProxies.
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, ip := range strings.Split(header, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+
+	// The last proxies are the closest ones, so the client is the first untrusted ip from the right
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			return remote
+		}
+		if !isTrustedProxy(forwarded[i]) {
+			return forwarded[i]
+		}
+		remote = forwarded[i]
+	}
+	return remote
+}
+
+// isTrustedProxy checks if the given ip is one of the TrustedProxies, that can be ips or CIDRs
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range configuration.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			_, network, err := net.ParseCIDR(proxy)
+			if err == nil && network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
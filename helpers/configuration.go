@@ -7,10 +7,106 @@ import (
 )
 
 // Configuration type to read configuration file
+// Lifetimes and clock skew are expressed in seconds.
 type Configuration struct {
-	IP         string
-	Port       int
-	ConnString string
+	IP                   string
+	Port                 int
+	ConnString           string
+	SigningKeyID         string
+	TokenKeys            []TokenKeyConfiguration
+	TokenHashKey         string
+	TokenFormat          string
+	Issuer               string
+	Audiences            []string
+	VerificationMode     string
+	DenylistInterval     int
+	AccessTokenLifetime  int
+	RefreshTokenLifetime int
+	ClockSkew            int
+	SessionIdleTimeout   int
+	SessionMaxLifetime   int
+	ReaperInterval       int
+	ReaperBatchSize      int
+	TokenCacheSize       int
+	TokenCacheStaleness  int
+	TrustedProxies       []string
+	Clients              []ClientConfiguration
+	OpenOAuthEndpoints   bool
+	SessionCookie        SessionCookieConfiguration
+	PasswordHash         PasswordHashConfiguration
+	PasswordPolicy       PasswordPolicyConfiguration
+	BreachedPasswords    BreachedPasswordsConfiguration
+}
+
+// TokenKeyConfiguration type to read a key used to sign or verify tokens.
+// HMAC keys (HS512 by default) use Secret or SecretFile, the asymmetric ones
+// (RS256, ES256, EdDSA...) use PEM encoded PrivateKeyFile or PublicKeyFile.
+// VerifyUntil is a RFC3339 date after which a retired key is no longer accepted, required
+// for every key but the signing one.
+type TokenKeyConfiguration struct {
+	ID             string
+	Algorithm      string
+	Secret         string
+	SecretFile     string
+	PrivateKeyFile string
+	PublicKeyFile  string
+	VerifyUntil    string
+}
+
+// ClientConfiguration type to read a client. Clients with a Secret are allowed to call
+// the OAuth endpoints, such as an API gateway introspecting tokens. Sessions can be
+// opened for any client with at most its Scopes.
+type ClientConfiguration struct {
+	ID     string
+	Secret string
+	Scopes []string
+}
+
+// SessionCookieConfiguration type to read the cookie that carries the token of
+// browser clients. The cookie is disabled unless Name is set. It is always HttpOnly
+// and Secure unless Insecure is set. SameSite is Strict, Lax or None, Lax by default.
+type SessionCookieConfiguration struct {
+	Name     string
+	Domain   string
+	Path     string
+	SameSite string
+	Insecure bool
+}
+
+// PasswordHashConfiguration type to read the algorithm of the new password hashes,
+// argon2id (default), scrypt or bcrypt, and its parameters. Argon2Memory is expressed
+// in KiB and ScryptLogN is the base 2 logarithm of the scrypt cost. Parameters left
+// to zero take their defaults.
+type PasswordHashConfiguration struct {
+	Algorithm     string
+	BcryptCost    int
+	ScryptLogN    int
+	ScryptR       int
+	ScryptP       int
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+}
+
+// PasswordPolicyConfiguration type to read the rules of the new passwords. MinLength is
+// 8 and MaxLength 128 characters by default. Passwords cannot contain the user name or
+// email unless AllowUserInfo is set.
+type PasswordPolicyConfiguration struct {
+	MinLength        int
+	MaxLength        int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	AllowUserInfo    bool
+}
+
+// BreachedPasswordsConfiguration type to read the dataset of breached passwords new
+// passwords are checked against, either a BloomFilter file built by cmd/breachfilter or
+// a RangeDirectory of Have I Been Pwned range files. The check is disabled without them.
+type BreachedPasswordsConfiguration struct {
+	BloomFilter    string
+	RangeDirectory string
 }
 
 var configuration Configuration
@@ -0,0 +1,59 @@
+package helpers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionCookieEnabled checks if tokens are sent to and accepted from a cookie
+func SessionCookieEnabled() bool {
+	return configuration.SessionCookie.Name != ""
+}
+
+// SessionCookieName returns the name of the cookie that carries the token
+func SessionCookieName() string {
+	return configuration.SessionCookie.Name
+}
+
+// NewSessionCookie returns the cookie that carries the given token until it expires
+func NewSessionCookie(token string) *http.Cookie {
+	cookie := newSessionCookie(token)
+	cookie.MaxAge = int(AccessTokenLifetime() / time.Second)
+	return cookie
+}
+
+// ExpiredSessionCookie returns a cookie that removes the token from the browser
+func ExpiredSessionCookie() *http.Cookie {
+	cookie := newSessionCookie("")
+	cookie.MaxAge = -1
+	return cookie
+}
+
+func newSessionCookie(value string) *http.Cookie {
+	config := configuration.SessionCookie
+	path := config.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     config.Name,
+		Value:    value,
+		Path:     path,
+		Domain:   config.Domain,
+		HttpOnly: true,
+		Secure:   !config.Insecure,
+		SameSite: sameSite(config.SameSite),
+	}
+}
+
+func sameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,27 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// CSRFHeader is the header that carries the CSRF token of cookie authenticated requests
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFToken returns the CSRF token of the session of the given token. It is derived
+// from the token, so it cannot be guessed by other sites that cannot read the cookie,
+// and it does not need to be stored.
+func CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte("csrf"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckCSRFToken checks the given CSRF token belongs to the session of the given token
+func CheckCSRFToken(sessionToken, csrfToken string) bool {
+	if sessionToken == "" || csrfToken == "" {
+		return false
+	}
+	return hmac.Equal([]byte(CSRFToken(sessionToken)), []byte(csrfToken))
+}
@@ -0,0 +1,273 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// HashArgon2id hashes passwords with Argon2id, the default
+	HashArgon2id = "argon2id"
+	// HashScrypt hashes passwords with scrypt
+	HashScrypt = "scrypt"
+	// HashBcrypt hashes passwords with bcrypt
+	HashBcrypt = "bcrypt"
+)
+
+const (
+	defaultScryptLogN    = 15
+	defaultScryptR       = 8
+	defaultScryptP       = 1
+	defaultArgon2Time    = 2
+	defaultArgon2Memory  = 19 * 1024
+	defaultArgon2Threads = 1
+	passwordSaltLength   = 16
+	passwordKeyLength    = 32
+)
+
+var (
+	// ErrHashMismatch is returned when a password does not match its hash
+	ErrHashMismatch = errors.New("The password does not match the hash")
+	// ErrHashUnknown is returned when a hash is malformed or of an unknown algorithm
+	ErrHashUnknown = errors.New("The hash is malformed or its algorithm is unknown")
+)
+
+// hashChecker checks passwords against the hashes of an algorithm
+type hashChecker interface {
+	// Check returns ErrHashMismatch if the given password does not match the encoded hash
+	Check(encoded, password string) error
+}
+
+// Hasher hashes passwords into self describing PHC strings, so hashes of different
+// algorithms and parameters can be stored together, and checks passwords against them
+type Hasher interface {
+	hashChecker
+	// Hash returns the PHC string of the given password with a random salt
+	Hash(password string) (string, error)
+	// NeedsRehash checks if the encoded hash is of another algorithm or has weaker parameters
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt. Its hashes keep the $2a$ modular crypt format.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of the given password
+func (bh BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bh.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Check checks the given password against a bcrypt hash
+func (bh BcryptHasher) Check(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrHashMismatch
+	}
+	if err != nil {
+		return ErrHashUnknown
+	}
+	return nil
+}
+
+// NeedsRehash checks if the given hash is not a bcrypt hash of at least the cost of the hasher
+func (bh BcryptHasher) NeedsRehash(encoded string) bool {
+	checker, err := checkerOf(encoded)
+	if _, ok := checker.(BcryptHasher); err != nil || !ok {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < bh.Cost
+}
+
+// ScryptHasher hashes passwords with scrypt into $scrypt$ln=15,r=8,p=1$salt$hash strings,
+// where 2^ln is the CPU and memory cost
+type ScryptHasher struct {
+	LogN, R, P int
+}
+
+// Hash returns the scrypt PHC string of the given password
+func (sh ScryptHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(sh.LogN), sh.R, sh.P, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", HashScrypt, sh.LogN, sh.R, sh.P,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check checks the given password against a scrypt PHC string
+func (sh ScryptHasher) Check(encoded, password string) error {
+	params, salt, key, err := decodePHC(encoded, HashScrypt, "ln", "r", "p")
+	if err != nil {
+		return err
+	}
+	if params["ln"] < 1 || params["ln"] > 30 {
+		return ErrHashUnknown
+	}
+	computed, err := scrypt.Key([]byte(password), salt, 1<<uint(params["ln"]), params["r"], params["p"], len(key))
+	if err != nil {
+		return ErrHashUnknown
+	}
+	return compareKeys(computed, key)
+}
+
+// NeedsRehash checks if the given hash is not a scrypt hash of at least the parameters of the hasher
+func (sh ScryptHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodePHC(encoded, HashScrypt, "ln", "r", "p")
+	return err != nil || params["ln"] < sh.LogN || params["r"] < sh.R || params["p"] < sh.P ||
+		len(key) < passwordKeyLength
+}
+
+// Argon2idHasher hashes passwords with Argon2id into $argon2id$v=19$m=19456,t=2,p=1$salt$hash
+// strings. Memory is expressed in KiB.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Hash returns the Argon2id PHC string of the given password
+func (ah Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, ah.Time, ah.Memory, ah.Threads, passwordKeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", HashArgon2id, argon2.Version, ah.Memory, ah.Time, ah.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check checks the given password against an Argon2id PHC string
+func (ah Argon2idHasher) Check(encoded, password string) error {
+	params, salt, key, err := decodePHC(encoded, HashArgon2id, "m", "t", "p")
+	if err != nil {
+		return err
+	}
+	if params["v"] != argon2.Version || params["p"] > 255 {
+		return ErrHashUnknown
+	}
+	computed := argon2.IDKey([]byte(password), salt, uint32(params["t"]), uint32(params["m"]), uint8(params["p"]), uint32(len(key)))
+	return compareKeys(computed, key)
+}
+
+// NeedsRehash checks if the given hash is not an Argon2id hash of at least the parameters of the hasher
+func (ah Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodePHC(encoded, HashArgon2id, "m", "t", "p")
+	return err != nil || params["v"] != argon2.Version || params["m"] < int(ah.Memory) || params["t"] < int(ah.Time) ||
+		params["p"] < int(ah.Threads) || len(key) < passwordKeyLength
+}
+
+// PasswordHasher returns the Hasher of the configured PasswordHash algorithm and parameters
+func PasswordHasher() Hasher {
+	config := configuration.PasswordHash
+	switch config.Algorithm {
+	case HashBcrypt:
+		return BcryptHasher{Cost: positiveOr(config.BcryptCost, bcrypt.DefaultCost)}
+	case HashScrypt:
+		return ScryptHasher{LogN: positiveOr(config.ScryptLogN, defaultScryptLogN),
+			R: positiveOr(config.ScryptR, defaultScryptR),
+			P: positiveOr(config.ScryptP, defaultScryptP)}
+	}
+	return Argon2idHasher{Time: uint32(positiveOr(config.Argon2Time, defaultArgon2Time)),
+		Memory:  uint32(positiveOr(config.Argon2Memory, defaultArgon2Memory)),
+		Threads: uint8(positiveOr(config.Argon2Threads, defaultArgon2Threads))}
+}
+
+// checkerOf returns the checker of the algorithm of the given hash
+func checkerOf(encoded string) (hashChecker, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$"+HashArgon2id+"$"):
+		return Argon2idHasher{}, nil
+	case strings.HasPrefix(encoded, "$"+HashScrypt+"$"):
+		return ScryptHasher{}, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return BcryptHasher{}, nil
+	case strings.HasPrefix(encoded, "$pbkdf2-"):
+		return pbkdf2Checker{}, nil
+	case strings.HasPrefix(encoded, "$"+LegacySaltedSHA1+"$"):
+		return saltedSHA1Checker{}, nil
+	case strings.HasPrefix(encoded, "$1$"):
+		return md5CryptChecker{}, nil
+	}
+	return nil, ErrHashUnknown
+}
+
+// decodePHC splits a $id[$v=version]$params$salt$hash string of the given algorithm and
+// returns its parameters, which must include the required ones and be positive
+func decodePHC(encoded, id string, required ...string) (map[string]int, []byte, []byte, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) == 6 && strings.HasPrefix(fields[2], "v=") {
+		fields = []string{fields[0], fields[1], fields[2] + "," + fields[3], fields[4], fields[5]}
+	}
+	if len(fields) != 5 || fields[0] != "" || fields[1] != id {
+		return nil, nil, nil, ErrHashUnknown
+	}
+
+	params := make(map[string]int)
+	for _, param := range strings.Split(fields[2], ",") {
+		pair := strings.SplitN(param, "=", 2)
+		if len(pair) != 2 {
+			return nil, nil, nil, ErrHashUnknown
+		}
+		value, err := strconv.Atoi(pair[1])
+		if err != nil || value <= 0 {
+			return nil, nil, nil, ErrHashUnknown
+		}
+		params[pair[0]] = value
+	}
+	for _, name := range required {
+		if _, ok := params[name]; !ok {
+			return nil, nil, nil, ErrHashUnknown
+		}
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return nil, nil, nil, ErrHashUnknown
+	}
+	key, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrHashUnknown
+	}
+	return params, salt, key, nil
+}
+
+func compareKeys(computed, key []byte) error {
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+func passwordSalt() ([]byte, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func positiveOr(value, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
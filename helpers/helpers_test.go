@@ -1,10 +1,29 @@
 package helpers
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
 	"flag"
+	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -12,7 +31,7 @@ var database = flag.Bool("database", false, "run database integration tests")
 
 func TestTokenizeAndDetokenize(t *testing.T) {
 	Convey("Given a text it can be tokenized and detokenized", t, func() {
-		token, err := Tokenize("example")
+		token, err := Tokenize("example", "session", "", CustomClaims{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -27,6 +46,29 @@ func TestTokenizeAndDetokenize(t *testing.T) {
 	})
 }
 
+func TestTokenIDs(t *testing.T) {
+	Convey("Given two tokens of the same user and session, they should be different", t, func() {
+		first, err := Tokenize("example", "session", "", CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := Tokenize("example", "session", "", CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		So(second, ShouldNotEqual, first)
+		So(TokenID(first), ShouldNotBeEmpty)
+		So(TokenID(second), ShouldNotEqual, TokenID(first))
+		So(TokenSessionID(first), ShouldEqual, "session")
+
+		claims, err := GetClaimsFromToken(first)
+		So(err, ShouldBeNil)
+		So(claims.Id, ShouldEqual, TokenID(first))
+		So(claims.SessionID, ShouldEqual, "session")
+		So(TokenID("qwepoinfsaldkjnvqpwoiuehfasdsckjndqo"), ShouldBeEmpty)
+	})
+}
+
 func TestDetokenizeWrongToken(t *testing.T) {
 	Convey("Given an invalid token it should return an error", t, func() {
 		_, err := GetFromToken("inventedtoken")
@@ -48,3 +90,690 @@ func TestHashsAndCheckHash(t *testing.T) {
 		}
 	})
 }
+
+func TestPasswordHashers(t *testing.T) {
+	Convey("Given the supported password hashers", t, func() {
+		const pass = "testPasswod"
+		hashers := map[string]Hasher{
+			"$argon2id$v=19$m=1024,t=1,p=1$": Argon2idHasher{Time: 1, Memory: 1024, Threads: 1},
+			"$scrypt$ln=10,r=8,p=1$":         ScryptHasher{LogN: 10, R: 8, P: 1},
+			"$2a$04$":                        BcryptHasher{Cost: 4},
+		}
+
+		for prefix, hasher := range hashers {
+			hashed, err := hasher.Hash(pass)
+			So(err, ShouldBeNil)
+			So(hashed, ShouldStartWith, prefix)
+			So(CheckHash(hashed, pass), ShouldBeNil)
+			So(CheckHash(hashed, "wrong"), ShouldEqual, ErrHashMismatch)
+
+			again, err := hasher.Hash(pass)
+			So(err, ShouldBeNil)
+			So(again, ShouldNotEqual, hashed)
+		}
+
+		Convey("Malformed and unknown hashes should not be checked", func() {
+			So(CheckHash("", pass), ShouldEqual, ErrHashUnknown)
+			So(CheckHash("$md5$abc", pass), ShouldEqual, ErrHashUnknown)
+			So(CheckHash("$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5", pass), ShouldEqual, ErrHashUnknown)
+			So(CheckHash("$scrypt$ln=10,r=8,p=1$c2FsdA$", pass), ShouldEqual, ErrHashUnknown)
+		})
+
+		Convey("The configured algorithm should hash new passwords", func() {
+			previous := configuration.PasswordHash
+			defer func() { configuration.PasswordHash = previous }()
+
+			configuration.PasswordHash = PasswordHashConfiguration{}
+			So(PasswordHasher(), ShouldResemble, Argon2idHasher{Time: 2, Memory: 19 * 1024, Threads: 1})
+			configuration.PasswordHash = PasswordHashConfiguration{Algorithm: HashBcrypt, BcryptCost: 12}
+			So(PasswordHasher(), ShouldResemble, BcryptHasher{Cost: 12})
+			configuration.PasswordHash = PasswordHashConfiguration{Algorithm: HashScrypt, ScryptLogN: 16}
+			So(PasswordHasher(), ShouldResemble, ScryptHasher{LogN: 16, R: 8, P: 1})
+		})
+	})
+}
+
+func TestNeedsRehash(t *testing.T) {
+	Convey("Given a configured algorithm, weaker hashes should need a rehash", t, func() {
+		previous := configuration.PasswordHash
+		defer func() { configuration.PasswordHash = previous }()
+		configuration.PasswordHash = PasswordHashConfiguration{Algorithm: HashArgon2id, Argon2Time: 1, Argon2Memory: 1024}
+
+		current, err := GenerateHash("testPasswod")
+		So(err, ShouldBeNil)
+		So(NeedsRehash(current), ShouldBeFalse)
+		stronger, err := Argon2idHasher{Time: 2, Memory: 2048, Threads: 1}.Hash("testPasswod")
+		So(err, ShouldBeNil)
+		So(NeedsRehash(stronger), ShouldBeFalse)
+		weaker, err := Argon2idHasher{Time: 1, Memory: 512, Threads: 1}.Hash("testPasswod")
+		So(err, ShouldBeNil)
+		So(NeedsRehash(weaker), ShouldBeTrue)
+		bcrypted, err := BcryptHasher{Cost: 4}.Hash("testPasswod")
+		So(err, ShouldBeNil)
+		So(NeedsRehash(bcrypted), ShouldBeTrue)
+		So(NeedsRehash("malformed"), ShouldBeTrue)
+
+		Convey("A higher bcrypt cost should need a rehash", func() {
+			configuration.PasswordHash = PasswordHashConfiguration{Algorithm: HashBcrypt, BcryptCost: 5}
+			So(NeedsRehash(bcrypted), ShouldBeTrue)
+			configuration.PasswordHash.BcryptCost = 4
+			So(NeedsRehash(bcrypted), ShouldBeFalse)
+			So(NeedsRehash(current), ShouldBeTrue)
+		})
+	})
+}
+
+func TestLegacyHashes(t *testing.T) {
+	Convey("Given hashes imported from other systems, they should be checked", t, func() {
+		hashes := map[string][]string{
+			LegacySaltedSHA1: {"59b3e8d637cf97edbe2384cf59cb7453dfe30789", "salt"},
+			LegacyPBKDF2:     {"pbkdf2_sha256$1000$somesalt$j4Aa14inUtOh7Sg/D7hH54ohymuHNQD4+ccfhepGWAY=", ""},
+			LegacyMD5Crypt:   {"$1$saltstri$qQY4WxjABChYG1ccLpfkz/", ""},
+		}
+		for algorithm, legacy := range hashes {
+			tagged, err := TagLegacyHash(algorithm, legacy[0], legacy[1])
+			So(err, ShouldBeNil)
+			So(CheckHash(tagged, "password"), ShouldBeNil)
+			So(CheckHash(tagged, "wrong"), ShouldEqual, ErrHashMismatch)
+			So(NeedsRehash(tagged), ShouldBeTrue)
+		}
+
+		So(md5Crypt([]byte("Hello world!"), []byte("saltstri")), ShouldEqual, "$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1")
+
+		Convey("Malformed hashes should not be tagged", func() {
+			_, err := TagLegacyHash(LegacySaltedSHA1, "abc", "salt")
+			So(err, ShouldEqual, ErrHashUnknown)
+			_, err = TagLegacyHash(LegacyPBKDF2, "pbkdf2_md5$1000$salt$aGFzaA==", "")
+			So(err, ShouldEqual, ErrHashUnknown)
+			_, err = TagLegacyHash(LegacyMD5Crypt, "$1$salt", "")
+			So(err, ShouldEqual, ErrHashUnknown)
+			_, err = TagLegacyHash("crc32", "abc", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	Convey("Given the default password policy", t, func() {
+		previous, previousHash := configuration.PasswordPolicy, configuration.PasswordHash
+		defer func() { configuration.PasswordPolicy, configuration.PasswordHash = previous, previousHash }()
+		configuration.PasswordPolicy = PasswordPolicyConfiguration{}
+
+		So(PasswordPolicy("correct horse", "bob", "bob@mail.com"), ShouldBeEmpty)
+		So(PasswordPolicy("short", "bob", "bob@mail.com"), ShouldResemble, []string{"The password must be at least 8 characters long"})
+		So(PasswordPolicy(strings.Repeat("a", 129), "bob", "bob@mail.com"), ShouldResemble, []string{"The password must be at most 128 characters long"})
+		So(PasswordPolicy("ñññññññ", "bob", "bob@mail.com"), ShouldHaveLength, 1)
+
+		Convey("Passwords should not contain the user name or email", func() {
+			So(PasswordPolicy("my name is Bobby", "bobby", "other@mail.com"), ShouldResemble, []string{"The password must not contain the user name"})
+			So(PasswordPolicy("alice@mail.com!", "bob", "alice@mail.com"), ShouldResemble, []string{"The password must not contain the email"})
+			So(PasswordPolicy("ALICE and friends", "bob", "alice@mail.com"), ShouldResemble, []string{"The password must not contain the email"})
+			configuration.PasswordPolicy.AllowUserInfo = true
+			So(PasswordPolicy("my name is Bobby", "bobby", "other@mail.com"), ShouldBeEmpty)
+		})
+
+		Convey("Character classes should be required if configured", func() {
+			configuration.PasswordPolicy = PasswordPolicyConfiguration{MinLength: 4, RequireLowercase: true, RequireUppercase: true, RequireDigit: true, RequireSymbol: true}
+			So(PasswordPolicy("aB3$", "bob", "bob@mail.com"), ShouldBeEmpty)
+			So(PasswordPolicy("abcd", "bob", "bob@mail.com"), ShouldResemble, []string{
+				"The password must contain an uppercase letter",
+				"The password must contain a digit",
+				"The password must contain a symbol"})
+		})
+
+		Convey("Passwords longer than 72 bytes should not be hashed with bcrypt", func() {
+			configuration.PasswordHash = PasswordHashConfiguration{Algorithm: HashBcrypt}
+			So(PasswordPolicy(strings.Repeat("ñ", 40), "bob", "bob@mail.com"), ShouldResemble, []string{"The password must be at most 72 bytes long"})
+		})
+	})
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	Convey("Given a Have I Been Pwned dataset of breached passwords", t, func() {
+		dir, err := ioutil.TempDir("", "breached")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		// The SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+		So(ioutil.WriteFile(filepath.Join(dir, "5BAA6"), []byte("1D2AC3A3B6A3A5C1E2B3F3C4E5F60718293:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "00000.txt"), []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:10\n"), 0600), ShouldBeNil)
+
+		previous := breachedPasswords
+		defer SetBreachedPasswords(previous)
+
+		Convey("The range files should be searched by prefix", func() {
+			SetBreachedPasswords(RangeDirectory(dir))
+			breached, err := PasswordBreached("password")
+			So(err, ShouldBeNil)
+			So(breached, ShouldBeTrue)
+			breached, err = PasswordBreached("correct horse battery staple")
+			So(err, ShouldNotBeNil)
+			So(breached, ShouldBeFalse)
+
+			violations, err := CheckNewPassword("password", "bob", "bob@mail.com")
+			So(err, ShouldBeNil)
+			So(violations, ShouldResemble, []string{"The password has appeared in a data breach"})
+		})
+
+		Convey("A bloom filter built from it should contain the same passwords", func() {
+			var hashes uint64
+			err := ForEachBreachedHash(dir, func(sum [sha1.Size]byte) error {
+				hashes++
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(hashes, ShouldEqual, 3)
+
+			filter := NewBloomFilter(hashes, 0.001)
+			So(ForEachBreachedHash(dir, func(sum [sha1.Size]byte) error {
+				filter.Add(sum)
+				return nil
+			}), ShouldBeNil)
+			buffer := new(bytes.Buffer)
+			_, err = filter.WriteTo(buffer)
+			So(err, ShouldBeNil)
+			written := buffer.Bytes()
+			read, err := ReadBloomFilter(bytes.NewReader(written), int64(len(written)))
+			So(err, ShouldBeNil)
+
+			SetBreachedPasswords(read)
+			breached, err := PasswordBreached("password")
+			So(err, ShouldBeNil)
+			So(breached, ShouldBeTrue)
+			breached, err = PasswordBreached("correct horse battery staple")
+			So(err, ShouldBeNil)
+			So(breached, ShouldBeFalse)
+
+			_, err = ReadBloomFilter(bytes.NewBufferString("not a filter"), 12)
+			So(err, ShouldEqual, ErrBloomFilterInvalid)
+
+			_, err = ReadBloomFilter(bytes.NewReader(written[:len(written)-1]), int64(len(written)-1))
+			So(err, ShouldEqual, ErrBloomFilterInvalid)
+
+			huge := append([]byte{}, written...)
+			binary.BigEndian.PutUint64(huge[8:16], math.MaxUint64)
+			_, err = ReadBloomFilter(bytes.NewReader(huge), int64(len(huge)))
+			So(err, ShouldEqual, ErrBloomFilterInvalid)
+
+			noHashes := append([]byte{}, written...)
+			binary.BigEndian.PutUint32(noHashes[4:8], 0)
+			_, err = ReadBloomFilter(bytes.NewReader(noHashes), int64(len(noHashes)))
+			So(err, ShouldEqual, ErrBloomFilterInvalid)
+		})
+
+		Convey("Without dataset no password should be breached", func() {
+			SetBreachedPasswords(nil)
+			breached, err := PasswordBreached("password")
+			So(err, ShouldBeNil)
+			So(breached, ShouldBeFalse)
+		})
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	Convey("Given a token signed with a key that has been rotated", t, func() {
+		previous := keyring
+		defer func() { keyring = previous }()
+
+		config := Configuration{
+			SigningKeyID: "old",
+			TokenKeys: []TokenKeyConfiguration{
+				{ID: "old", Secret: "an old secret that should be long enough"},
+				{ID: "new", Secret: "a new secret that should be long enough", VerifyUntil: time.Now().Add(time.Hour).Format(time.RFC3339)},
+			},
+		}
+		kr, err := newKeyringFromConfiguration(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyring = kr
+		token, err := Tokenize("example", "session", "", CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config.SigningKeyID = "new"
+		config.TokenKeys[0].VerifyUntil = time.Now().Add(time.Hour).Format(time.RFC3339)
+		Convey("It is still valid while the old key is in the keyring", func() {
+			kr, err := newKeyringFromConfiguration(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyring = kr
+			detoken, err := GetFromToken(token)
+			So(err, ShouldBeNil)
+			So(detoken, ShouldEqual, "example")
+		})
+
+		Convey("It is invalid once the old key has expired", func() {
+			config.TokenKeys[0].VerifyUntil = "2000-01-01T00:00:00Z"
+			kr, err := newKeyringFromConfiguration(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyring = kr
+			_, err = GetFromToken(token)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The old key cannot be kept without VerifyUntil", func() {
+			config.TokenKeys[0].VerifyUntil = ""
+			_, err := newKeyringFromConfiguration(config)
+			So(err, ShouldNotBeNil)
+
+			kr := NewKeyring()
+			So(kr.AddKey(NewHMACKey("old", []byte("an old secret that should be long enough"))), ShouldBeNil)
+			So(kr.AddKey(NewHMACKey("new", []byte("a new secret that should be long enough"))), ShouldBeNil)
+			So(kr.SetSigningKey("new"), ShouldBeNil)
+			_, err = kr.VerificationKey("old")
+			So(err, ShouldNotBeNil)
+			So(kr.Keys(), ShouldHaveLength, 1)
+		})
+
+		Convey("It is invalid once the old key is removed", func() {
+			config.TokenKeys = config.TokenKeys[1:]
+			kr, err := newKeyringFromConfiguration(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyring = kr
+			_, err = GetFromToken(token)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func signTestToken(claims TokenClaims) string {
+	key, err := getKeyring().SigningKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.SignKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestTokenExpiration(t *testing.T) {
+	Convey("Given a token with time claims", t, func() {
+		now := time.Now()
+
+		Convey("It is expired after its expiration date", func() {
+			token := signTestToken(TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				Issuer:    Issuer(),
+				IssuedAt:  now.Add(-2 * time.Hour).Unix(),
+				ExpiresAt: now.Add(-time.Hour).Unix(),
+			}})
+			_, err := GetFromToken(token)
+			So(err, ShouldEqual, ErrTokenExpired)
+		})
+
+		Convey("It is still valid inside the clock skew", func() {
+			token := signTestToken(TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				Issuer:    Issuer(),
+				IssuedAt:  now.Add(5 * time.Second).Unix(),
+				NotBefore: now.Add(5 * time.Second).Unix(),
+				ExpiresAt: now.Add(-5 * time.Second).Unix(),
+			}})
+			detoken, err := GetFromToken(token)
+			So(err, ShouldBeNil)
+			So(detoken, ShouldEqual, "example")
+		})
+
+		Convey("It is invalid before its not before date", func() {
+			token := signTestToken(TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				Issuer:    Issuer(),
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Add(time.Hour).Unix(),
+				ExpiresAt: now.Add(2 * time.Hour).Unix(),
+			}})
+			_, err := GetFromToken(token)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+
+		Convey("It is invalid without expiration date", func() {
+			token := signTestToken(TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				Issuer:   Issuer(),
+				IssuedAt: now.Unix(),
+			}})
+			_, err := GetFromToken(token)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+
+		Convey("It is invalid if it is forged, even if it has expired", func() {
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS512, TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				IssuedAt:  now.Add(-2 * time.Hour).Unix(),
+				ExpiresAt: now.Add(-time.Hour).Unix(),
+			}})
+			key, _ := getKeyring().SigningKey()
+			forged.Header["kid"] = key.ID
+			token, err := forged.SignedString([]byte("SecretKey"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = GetFromToken(token)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+	})
+}
+
+func writeTestPrivateKey(t *testing.T, dir, name string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, name)
+	err = ioutil.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	Convey("Given RSA, ECDSA and Ed25519 keys", t, func() {
+		previous := keyring
+		defer func() { keyring = previous }()
+
+		dir, err := ioutil.TempDir("", "keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifyUntil := time.Now().Add(time.Hour).Format(time.RFC3339)
+		config := Configuration{
+			TokenKeys: []TokenKeyConfiguration{
+				{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: writeTestPrivateKey(t, dir, "rsa.pem", rsaKey), VerifyUntil: verifyUntil},
+				{ID: "ec", Algorithm: "ES256", PrivateKeyFile: writeTestPrivateKey(t, dir, "ec.pem", ecKey), VerifyUntil: verifyUntil},
+				{ID: "ed", Algorithm: "EdDSA", PrivateKeyFile: writeTestPrivateKey(t, dir, "ed.pem", edKey), VerifyUntil: verifyUntil},
+				{ID: "hmac", Secret: "a secret that should be long enough", VerifyUntil: verifyUntil},
+			},
+		}
+
+		for _, id := range []string{"rsa", "ec", "ed"} {
+			config.SigningKeyID = id
+			Convey("Tokens signed with the "+id+" key can be verified", func() {
+				kr, err := newKeyringFromConfiguration(config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keyring = kr
+				token, err := Tokenize("example", "session", "", CustomClaims{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				detoken, err := GetFromToken(token)
+				So(err, ShouldBeNil)
+				So(detoken, ShouldEqual, "example")
+			})
+		}
+
+		Convey("The public keys are published but the HMAC one is not", func() {
+			kr, err := newKeyringFromConfiguration(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyring = kr
+			jwks := GetJWKS()
+			So(len(jwks.Keys), ShouldEqual, 3)
+			So(jwks.Keys[0].Kid, ShouldEqual, "ec")
+			So(jwks.Keys[0].Kty, ShouldEqual, "EC")
+			So(jwks.Keys[0].Crv, ShouldEqual, "P-256")
+			So(jwks.Keys[1].Kid, ShouldEqual, "ed")
+			So(jwks.Keys[1].Kty, ShouldEqual, "OKP")
+			So(jwks.Keys[2].Kid, ShouldEqual, "rsa")
+			So(jwks.Keys[2].Kty, ShouldEqual, "RSA")
+			So(jwks.Keys[2].E, ShouldEqual, "AQAB")
+		})
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	Convey("Given a request forwarded by proxies", t, func() {
+		previous := configuration.TrustedProxies
+		defer func() { configuration.TrustedProxies = previous }()
+		configuration.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8, 10.1.1.1")
+
+		Convey("The forwarded ip is ignored if the request does not come from a trusted proxy", func() {
+			req.RemoteAddr = "8.8.8.8:1234"
+			So(ClientIP(req), ShouldEqual, "8.8.8.8")
+		})
+
+		Convey("The first untrusted ip from the right is the client", func() {
+			req.RemoteAddr = "192.168.1.1:1234"
+			So(ClientIP(req), ShouldEqual, "5.6.7.8")
+		})
+
+		Convey("If all the ips are trusted the first one is the client", func() {
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "10.2.2.2, 10.1.1.1")
+			So(ClientIP(req), ShouldEqual, "10.2.2.2")
+		})
+	})
+}
+
+func TestHashToken(t *testing.T) {
+	Convey("Given a token, it should be hashed", t, func() {
+		previous := configuration.TokenHashKey
+		defer func() { configuration.TokenHashKey = previous }()
+
+		configuration.TokenHashKey = ""
+		hash := HashToken("example")
+		So(hash, ShouldEqual, "50d858e0985ecc7f60418aaf0cc5ab587f42c2570a884095a9e8ccacd0f6545c")
+		So(HashToken("example"), ShouldEqual, hash)
+
+		Convey("With a key it should be a different HMAC", func() {
+			configuration.TokenHashKey = "a key"
+			keyed := HashToken("example")
+			So(len(keyed), ShouldEqual, 64)
+			So(keyed, ShouldNotEqual, hash)
+		})
+	})
+}
+
+func TestIssueToken(t *testing.T) {
+	Convey("Given a token format, tokens should be issued in it", t, func() {
+		previous := configuration.TokenFormat
+		defer func() { configuration.TokenFormat = previous }()
+
+		configuration.TokenFormat = ""
+		token, err := IssueToken("testID", "session", "", CustomClaims{})
+		So(err, ShouldBeNil)
+		So(IsOpaqueToken(token), ShouldBeFalse)
+		id, err := GetFromToken(token)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "testID")
+
+		Convey("Opaque tokens should not be JWTs", func() {
+			configuration.TokenFormat = TokenFormatOpaque
+			opaque, err := IssueToken("testID", "session", "", CustomClaims{})
+			So(err, ShouldBeNil)
+			So(IsOpaqueToken(opaque), ShouldBeTrue)
+			So(opaque, ShouldNotContainSubstring, "testID")
+			_, err = GetFromToken(opaque)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+	})
+}
+
+func TestAudience(t *testing.T) {
+	Convey("Given configured audiences, only them should be allowed", t, func() {
+		previous := configuration.Audiences
+		defer func() { configuration.Audiences = previous }()
+
+		configuration.Audiences = nil
+		So(AudienceAllowed("anything"), ShouldBeTrue)
+		configuration.Audiences = []string{"api", "admin"}
+		So(AudienceAllowed("admin"), ShouldBeTrue)
+		So(AudienceAllowed(""), ShouldBeTrue)
+		So(AudienceAllowed("other"), ShouldBeFalse)
+	})
+
+	Convey("Given a token issued for an audience, it should have the issuer and audience", t, func() {
+		previous := configuration.Issuer
+		defer func() { configuration.Issuer = previous }()
+
+		token, err := Tokenize("example", "session", "api", CustomClaims{})
+		So(err, ShouldBeNil)
+		claims, err := GetClaimsFromToken(token)
+		So(err, ShouldBeNil)
+		So(claims.Issuer, ShouldEqual, defaultIssuer)
+		So(claims.Audience, ShouldEqual, "api")
+		So(TokenAudience(token), ShouldEqual, "api")
+
+		Convey("It should be invalid for another issuer", func() {
+			configuration.Issuer = "Another"
+			_, err := GetClaimsFromToken(token)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+
+		Convey("It should be invalid without issuer", func() {
+			token := signTestToken(TokenClaims{ID: "example", StandardClaims: jwt.StandardClaims{
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			}})
+			_, err := GetClaimsFromToken(token)
+			So(err, ShouldEqual, ErrTokenInvalid)
+		})
+	})
+}
+
+func TestCustomClaims(t *testing.T) {
+	Convey("Given a token with custom claims, they should be kept in it", t, func() {
+		token, err := Tokenize("example", "session", "", CustomClaims{Roles: []string{"admin"}, Claims: map[string]interface{}{"tenant": "acme"}})
+		So(err, ShouldBeNil)
+		claims, err := GetClaimsFromToken(token)
+		So(err, ShouldBeNil)
+		So(claims.Roles, ShouldResemble, []string{"admin"})
+		So(claims.Claims["tenant"], ShouldEqual, "acme")
+
+		custom, ok := TokenCustomClaims(token)
+		So(ok, ShouldBeTrue)
+		So(custom.Roles, ShouldResemble, []string{"admin"})
+		_, ok = TokenCustomClaims("qwepoinfsaldkjnvqpwoiuehfasdsckjndqo")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Given claims enrichers, they should run in order until one fails", t, func() {
+		previous := claimsEnrichers
+		defer func() { claimsEnrichers = previous }()
+		claimsEnrichers = nil
+
+		AddClaimsEnricher(func(userID string, claims *CustomClaims) error {
+			claims.Claims = map[string]interface{}{"user": userID}
+			return nil
+		})
+		claims := CustomClaims{Roles: []string{"admin"}}
+		So(EnrichClaims("example", &claims), ShouldBeNil)
+		So(claims.Roles, ShouldResemble, []string{"admin"})
+		So(claims.Claims["user"], ShouldEqual, "example")
+
+		failure := errors.New("failure")
+		AddClaimsEnricher(func(userID string, claims *CustomClaims) error { return failure })
+		So(EnrichClaims("example", &claims), ShouldEqual, failure)
+
+		SetClaimsEnrichers()
+		So(EnrichClaims("example", &claims), ShouldBeNil)
+	})
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	Convey("Given configured clients, only their credentials should be accepted", t, func() {
+		previous, previousOpen := configuration.Clients, configuration.OpenOAuthEndpoints
+		defer func() { configuration.Clients, configuration.OpenOAuthEndpoints = previous, previousOpen }()
+
+		configuration.Clients = nil
+		configuration.OpenOAuthEndpoints = false
+		So(ClientAuthenticationRequired(), ShouldBeTrue)
+		So(AuthenticateClient("", ""), ShouldBeFalse)
+
+		configuration.OpenOAuthEndpoints = true
+		So(ClientAuthenticationRequired(), ShouldBeFalse)
+
+		SetClients([]ClientConfiguration{{ID: "gateway", Secret: "a secret"}, {ID: "nosecret"}})
+		So(ClientAuthenticationRequired(), ShouldBeTrue)
+		So(AuthenticateClient("gateway", "a secret"), ShouldBeTrue)
+		So(AuthenticateClient("gateway", "another secret"), ShouldBeFalse)
+		So(AuthenticateClient("nosecret", ""), ShouldBeFalse)
+		So(AuthenticateClient("", ""), ShouldBeFalse)
+	})
+}
+
+func TestClientScopeAllowed(t *testing.T) {
+	Convey("Given configured clients, sessions should only be opened with their scopes", t, func() {
+		previous := configuration.Clients
+		defer func() { configuration.Clients = previous }()
+
+		configuration.Clients = []ClientConfiguration{{ID: "webapp", Scopes: []string{"read", "write"}}, {ID: "gateway", Secret: "a secret"}}
+		So(ClientScopeAllowed("", ""), ShouldBeTrue)
+		So(ClientScopeAllowed("", "read"), ShouldBeFalse)
+		So(ClientScopeAllowed("webapp", ""), ShouldBeTrue)
+		So(ClientScopeAllowed("webapp", "read  write"), ShouldBeTrue)
+		So(ClientScopeAllowed("webapp", "read admin"), ShouldBeFalse)
+		So(ClientScopeAllowed("gateway", "read"), ShouldBeFalse)
+		So(ClientScopeAllowed("unknown", ""), ShouldBeFalse)
+	})
+}
+
+func TestSessionCookie(t *testing.T) {
+	Convey("Given a session cookie configuration, the cookies should follow it", t, func() {
+		previous := configuration.SessionCookie
+		defer func() { configuration.SessionCookie = previous }()
+
+		configuration.SessionCookie = SessionCookieConfiguration{}
+		So(SessionCookieEnabled(), ShouldBeFalse)
+
+		configuration.SessionCookie = SessionCookieConfiguration{Name: "session", SameSite: "Strict"}
+		So(SessionCookieEnabled(), ShouldBeTrue)
+		cookie := NewSessionCookie("a token")
+		So(cookie.Name, ShouldEqual, "session")
+		So(cookie.Value, ShouldEqual, "a token")
+		So(cookie.Path, ShouldEqual, "/")
+		So(cookie.HttpOnly, ShouldBeTrue)
+		So(cookie.Secure, ShouldBeTrue)
+		So(cookie.SameSite, ShouldEqual, http.SameSiteStrictMode)
+		So(cookie.MaxAge, ShouldEqual, int(AccessTokenLifetime()/time.Second))
+
+		Convey("The expired cookie should remove the token", func() {
+			configuration.SessionCookie.Insecure = true
+			expired := ExpiredSessionCookie()
+			So(expired.Value, ShouldEqual, "")
+			So(expired.MaxAge, ShouldBeLessThan, 0)
+			So(expired.Secure, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCSRFToken(t *testing.T) {
+	Convey("Given a session token, its CSRF token should only be valid for it", t, func() {
+		csrf := CSRFToken("a token")
+		So(csrf, ShouldNotBeEmpty)
+		So(csrf, ShouldNotContainSubstring, "a token")
+		So(CheckCSRFToken("a token", csrf), ShouldBeTrue)
+		So(CheckCSRFToken("another token", csrf), ShouldBeFalse)
+		So(CheckCSRFToken("a token", ""), ShouldBeFalse)
+		So(CheckCSRFToken("", CSRFToken("")), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,364 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is a key of the keyring used to sign or verify tokens
+type SigningKey struct {
+	ID          string
+	Method      jwt.SigningMethod
+	SignKey     interface{}
+	VerifyKey   interface{}
+	VerifyUntil time.Time
+}
+
+// Keyring holds the keys used to sign and verify tokens. Only one key signs new
+// tokens, but tokens signed by any other key of the keyring are still accepted
+// until the key expires, so keys can be rotated without logging everyone out.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]*SigningKey
+	current string
+}
+
+var (
+	keyring     *Keyring
+	keyringLock sync.Mutex
+)
+
+// NewKeyring creates an empty Keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*SigningKey)}
+}
+
+// AddKey adds the given key to the keyring
+func (kr *Keyring) AddKey(key *SigningKey) error {
+	if key.ID == "" {
+		return fmt.Errorf("key id cannot be void string")
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, exists := kr.keys[key.ID]; exists {
+		return fmt.Errorf("repeated key id %s", key.ID)
+	}
+	kr.keys[key.ID] = key
+	return nil
+}
+
+// SetSigningKey sets the key used to sign new tokens
+func (kr *Keyring) SetSigningKey(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	key, exists := kr.keys[id]
+	if !exists {
+		return fmt.Errorf("unknown key id %s", id)
+	}
+	if key.SignKey == nil {
+		return fmt.Errorf("key %s cannot sign tokens", id)
+	}
+	kr.current = id
+	return nil
+}
+
+// SigningKey returns the key used to sign new tokens
+func (kr *Keyring) SigningKey() (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, exists := kr.keys[kr.current]
+	if !exists {
+		return nil, fmt.Errorf("there is no signing key")
+	}
+	return key, nil
+}
+
+// VerificationKey returns the key with the given id if it can still verify tokens
+func (kr *Keyring) VerificationKey(id string) (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, exists := kr.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("unknown key id %s", id)
+	}
+	if kr.retired(key) {
+		return nil, fmt.Errorf("key %s has been retired", id)
+	}
+	return key, nil
+}
+
+// NewHMACKey creates an HS512 key from the given secret
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS512,
+		SignKey:   secret,
+		VerifyKey: secret,
+	}
+}
+
+// Keys returns the keys that can still verify tokens
+func (kr *Keyring) Keys() []*SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	keys := make([]*SigningKey, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		if kr.retired(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// retired checks if the given key can no longer verify tokens. Every key but the signing
+// one needs a VerifyUntil, so a key retired without it is never accepted.
+func (kr *Keyring) retired(key *SigningKey) bool {
+	if key.ID == kr.current {
+		return false
+	}
+	return key.VerifyUntil.IsZero() || time.Now().After(key.VerifyUntil)
+}
+
+// InitKeyring loads the token keys from a given json formated file
+func InitKeyring(configFileName string) error {
+	loadConfig(configFileName)
+	kr, err := newKeyringFromConfiguration(configuration)
+	if err != nil {
+		return err
+	}
+	keyringLock.Lock()
+	keyring = kr
+	keyringLock.Unlock()
+	return nil
+}
+
+func newKeyringFromConfiguration(config Configuration) (*Keyring, error) {
+	if len(config.TokenKeys) == 0 {
+		return nil, fmt.Errorf("there are no token keys configured")
+	}
+	kr := NewKeyring()
+	for _, keyConfig := range config.TokenKeys {
+		key, err := loadKey(keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		if keyConfig.VerifyUntil != "" {
+			key.VerifyUntil, err = time.Parse(time.RFC3339, keyConfig.VerifyUntil)
+			if err != nil {
+				return nil, fmt.Errorf("invalid VerifyUntil of key %s: %v", keyConfig.ID, err)
+			}
+		}
+		if err := kr.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	signingKeyID := config.SigningKeyID
+	if signingKeyID == "" {
+		signingKeyID = config.TokenKeys[0].ID
+	}
+	if err := kr.SetSigningKey(signingKeyID); err != nil {
+		return nil, err
+	}
+	for _, keyConfig := range config.TokenKeys {
+		if keyConfig.ID != signingKeyID && keyConfig.VerifyUntil == "" {
+			return nil, fmt.Errorf("key %s does not sign tokens and has no VerifyUntil", keyConfig.ID)
+		}
+	}
+	return kr, nil
+}
+
+func loadKey(keyConfig TokenKeyConfiguration) (*SigningKey, error) {
+	algorithm := keyConfig.Algorithm
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS512.Alg()
+	}
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unknown algorithm %s of key %s", algorithm, keyConfig.ID)
+	}
+	key := &SigningKey{ID: keyConfig.ID, Method: method}
+
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		secret, err := readSecret(keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey = secret
+		key.VerifyKey = secret
+		return key, nil
+	}
+
+	if keyConfig.PrivateKeyFile == "" && keyConfig.PublicKeyFile == "" {
+		return nil, fmt.Errorf("key %s has no private or public key file", keyConfig.ID)
+	}
+
+	if keyConfig.PrivateKeyFile != "" {
+		pem, err := ioutil.ReadFile(keyConfig.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading private key of key %s: %v", keyConfig.ID, err)
+		}
+		key.SignKey, key.VerifyKey, err = parsePrivateKey(method, pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing private key of key %s: %v", keyConfig.ID, err)
+		}
+	}
+
+	if keyConfig.PublicKeyFile != "" {
+		pem, err := ioutil.ReadFile(keyConfig.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading public key of key %s: %v", keyConfig.ID, err)
+		}
+		key.VerifyKey, err = parsePublicKey(method, pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing public key of key %s: %v", keyConfig.ID, err)
+		}
+	}
+	return key, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS8, PKCS1 or SEC1 private key for the
+// given method and returns it along with its public key
+func parsePrivateKey(method jwt.SigningMethod, key []byte) (interface{}, interface{}, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes); rsaErr == nil {
+			parsed, err = rsaKey, nil
+		} else if ecKey, ecErr := x509.ParseECPrivateKey(block.Bytes); ecErr == nil {
+			parsed, err = ecKey, nil
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		if err := checkKeyMethod(method, &privateKey.PublicKey); err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		if err := checkKeyMethod(method, &privateKey.PublicKey); err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case ed25519.PrivateKey:
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+		if err := checkKeyMethod(method, publicKey); err != nil {
+			return nil, nil, err
+		}
+		return privateKey, publicKey, nil
+	}
+	return nil, nil, jwt.ErrInvalidKeyType
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key or certificate for the given method
+func parsePublicKey(method jwt.SigningMethod, key []byte) (interface{}, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		cert, certErr := x509.ParseCertificate(block.Bytes)
+		if certErr != nil {
+			return nil, err
+		}
+		parsed = cert.PublicKey
+	}
+
+	if err := checkKeyMethod(method, parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// checkKeyMethod checks that the given public key can be used with the given method
+func checkKeyMethod(method jwt.SigningMethod, publicKey interface{}) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := publicKey.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if ecKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+			if ecKey.Curve.Params().BitSize != m.CurveBits {
+				return fmt.Errorf("the curve of the key does not match %s", m.Alg())
+			}
+			return nil
+		}
+	case *SigningMethodEdDSA:
+		if _, ok := publicKey.(ed25519.PublicKey); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("the key cannot be used with %s", method.Alg())
+}
+
+func readSecret(keyConfig TokenKeyConfiguration) ([]byte, error) {
+	secret := keyConfig.Secret
+	if keyConfig.SecretFile != "" {
+		content, err := ioutil.ReadFile(keyConfig.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading secret of key %s: %v", keyConfig.ID, err)
+		}
+		secret = strings.TrimSpace(string(content))
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("key %s has no secret", keyConfig.ID)
+	}
+	if len(secret) < 32 {
+		log.Printf("The secret of key %s is shorter than 32 bytes", keyConfig.ID)
+	}
+	return []byte(secret), nil
+}
+
+// SetKeyring replaces the keyring that signs and verifies tokens, nil to use an ephemeral key
+func SetKeyring(kr *Keyring) {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	keyring = kr
+}
+
+// getKeyring returns the loaded keyring. If none was loaded it creates one with a
+// random key, so tokens will not survive a restart of the server.
+func getKeyring() *Keyring {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	if keyring == nil {
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed generating token key: %v", err)
+		}
+		kr := NewKeyring()
+		key := NewHMACKey("ephemeral-"+hex.EncodeToString(secret[:4]), secret)
+		kr.AddKey(key)
+		kr.SetSigningKey(key.ID)
+		log.Printf("No token keys loaded, using the ephemeral key %s", key.ID)
+		keyring = kr
+	}
+	return keyring
+}
@@ -0,0 +1,198 @@
+package helpers
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The algorithms of the password hashes that can be imported from other systems. Their
+// hashes can be checked but new ones are never generated, they are replaced by hashes
+// of the configured PasswordHasher on the first login of every user.
+const (
+	// LegacySaltedSHA1 is the hex encoded SHA-1 of the salt followed by the password
+	LegacySaltedSHA1 = "sha1"
+	// LegacyPBKDF2 is a PBKDF2 hash as exported by Django, pbkdf2_sha256$iterations$salt$hash
+	LegacyPBKDF2 = "pbkdf2"
+	// LegacyMD5Crypt is an MD5-crypt hash, $1$salt$hash
+	LegacyMD5Crypt = "md5crypt"
+)
+
+const md5CryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// TagLegacyHash returns a hash exported by another system tagged with its algorithm, so
+// it can be stored verbatim and checked by CheckHash. The salt is only needed by
+// LegacySaltedSHA1, the other hashes carry their own.
+func TagLegacyHash(algorithm, legacyHash, salt string) (string, error) {
+	switch algorithm {
+	case LegacySaltedSHA1:
+		if len(legacyHash) != 2*sha1.Size {
+			return "", ErrHashUnknown
+		}
+		if _, err := hex.DecodeString(legacyHash); err != nil {
+			return "", ErrHashUnknown
+		}
+		return fmt.Sprintf("$%s$%s$%s", LegacySaltedSHA1, base64.RawStdEncoding.EncodeToString([]byte(salt)), strings.ToLower(legacyHash)), nil
+	case LegacyPBKDF2:
+		return tagPBKDF2(legacyHash)
+	case LegacyMD5Crypt:
+		if (md5CryptChecker{}).Check(legacyHash, "") == ErrHashUnknown {
+			return "", ErrHashUnknown
+		}
+		return legacyHash, nil
+	}
+	return "", fmt.Errorf("unknown legacy hash algorithm %s", algorithm)
+}
+
+// tagPBKDF2 converts a Django pbkdf2_<digest>$<iterations>$<salt>$<base64 hash> into a
+// $pbkdf2-<digest>$i=<iterations>$<salt>$<hash> PHC string
+func tagPBKDF2(legacyHash string) (string, error) {
+	fields := strings.Split(legacyHash, "$")
+	if len(fields) != 4 || !strings.HasPrefix(fields[0], "pbkdf2_") {
+		return "", ErrHashUnknown
+	}
+	digest := strings.TrimPrefix(fields[0], "pbkdf2_")
+	if _, ok := pbkdf2Digests[digest]; !ok {
+		return "", ErrHashUnknown
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations <= 0 {
+		return "", ErrHashUnknown
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil || len(key) == 0 {
+		return "", ErrHashUnknown
+	}
+	return fmt.Sprintf("$pbkdf2-%s$i=%d$%s$%s", digest, iterations,
+		base64.RawStdEncoding.EncodeToString([]byte(fields[2])), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// saltedSHA1Checker checks $sha1$salt$hex hashes
+type saltedSHA1Checker struct{}
+
+func (saltedSHA1Checker) Check(encoded, password string) error {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 4 || fields[0] != "" || fields[1] != LegacySaltedSHA1 {
+		return ErrHashUnknown
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return ErrHashUnknown
+	}
+	key, err := hex.DecodeString(fields[3])
+	if err != nil || len(key) != sha1.Size {
+		return ErrHashUnknown
+	}
+	sum := sha1.Sum(append(salt, password...))
+	return compareKeys(sum[:], key)
+}
+
+// pbkdf2Checker checks $pbkdf2-<digest>$i=iterations$salt$hash hashes
+type pbkdf2Checker struct{}
+
+func (pbkdf2Checker) Check(encoded, password string) error {
+	id := strings.SplitN(strings.TrimPrefix(encoded, "$"), "$", 2)[0]
+	digest, ok := pbkdf2Digests[strings.TrimPrefix(id, "pbkdf2-")]
+	if !ok {
+		return ErrHashUnknown
+	}
+	params, salt, key, err := decodePHC(encoded, id, "i")
+	if err != nil {
+		return err
+	}
+	return compareKeys(pbkdf2.Key([]byte(password), salt, params["i"], len(key), digest), key)
+}
+
+// md5CryptChecker checks $1$salt$hash hashes
+type md5CryptChecker struct{}
+
+func (md5CryptChecker) Check(encoded, password string) error {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 4 || fields[0] != "" || fields[1] != "1" || len(fields[2]) > 8 || len(fields[3]) != 22 {
+		return ErrHashUnknown
+	}
+	computed := md5Crypt([]byte(password), []byte(fields[2]))
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(encoded)) != 1 {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// md5Crypt returns the MD5-crypt hash of the given password and salt of at most 8 bytes
+func md5Crypt(password, salt []byte) string {
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	mixin := alternate.Sum(nil)
+
+	digest := md5.New()
+	digest.Write(password)
+	digest.Write([]byte("$1$"))
+	digest.Write(salt)
+	for i := len(password); i > 0; i -= md5.Size {
+		if i > md5.Size {
+			digest.Write(mixin)
+		} else {
+			digest.Write(mixin[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write(password[:1])
+		}
+	}
+	final := digest.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	encoded := make([]byte, 0, 22)
+	encode := func(value uint, length int) {
+		for ; length > 0; length-- {
+			encoded = append(encoded, md5CryptAlphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[group[0]])<<16|uint(final[group[1]])<<8|uint(final[group[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+	return "$1$" + string(salt) + "$" + string(encoded)
+}
@@ -1,17 +1,22 @@
 package helpers
 
-import "golang.org/x/crypto/bcrypt"
-
-//GenerateHash from a given string
+//GenerateHash from a given string with the configured PasswordHasher
 func GenerateHash(text string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
+	return PasswordHasher().Hash(text)
+}
+
+//CheckHash from a given hasedText and a Text. The algorithm is taken from the hash, so any
+//supported one can be checked. It returns ErrHashMismatch if the text does not match.
+func CheckHash(hashedText, text string) error {
+	checker, err := checkerOf(hashedText)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return string(hashed[:]), nil
+	return checker.Check(hashedText, text)
 }
 
-//CheckHash from a given hasedText and a Text
-func CheckHash(hashedText, text string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedText), []byte(text))
+//NeedsRehash checks if a given hashedText is below the configured PasswordHash algorithm and
+//parameters, so the password must be hashed again once it has been checked
+func NeedsRehash(hashedText string) bool {
+	return PasswordHasher().NeedsRehash(hashedText)
 }
@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = 128
+	// bcryptMaxLength is the number of bytes of a password bcrypt takes into account
+	bcryptMaxLength = 72
+	// minUserInfoLength is the shortest user name or email not allowed inside a password
+	minUserInfoLength = 3
+)
+
+// PasswordPolicy returns the rules broken by the password of the user with the given name
+// and email, or none if the password is valid. The lengths are counted in characters.
+func PasswordPolicy(password, userName, email string) []string {
+	policy := configuration.PasswordPolicy
+	var violations []string
+
+	minLength := positiveOr(policy.MinLength, defaultPasswordMinLength)
+	maxLength := positiveOr(policy.MaxLength, defaultPasswordMaxLength)
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		violations = append(violations, fmt.Sprintf("The password must be at least %d characters long", minLength))
+	}
+	if length > maxLength {
+		violations = append(violations, fmt.Sprintf("The password must be at most %d characters long", maxLength))
+	}
+	if _, ok := PasswordHasher().(BcryptHasher); ok && len(password) > bcryptMaxLength {
+		violations = append(violations, fmt.Sprintf("The password must be at most %d bytes long", bcryptMaxLength))
+	}
+
+	if policy.RequireLowercase && strings.IndexFunc(password, unicode.IsLower) < 0 {
+		violations = append(violations, "The password must contain a lowercase letter")
+	}
+	if policy.RequireUppercase && strings.IndexFunc(password, unicode.IsUpper) < 0 {
+		violations = append(violations, "The password must contain an uppercase letter")
+	}
+	if policy.RequireDigit && strings.IndexFunc(password, unicode.IsDigit) < 0 {
+		violations = append(violations, "The password must contain a digit")
+	}
+	if policy.RequireSymbol && strings.IndexFunc(password, isSymbol) < 0 {
+		violations = append(violations, "The password must contain a symbol")
+	}
+
+	if !policy.AllowUserInfo {
+		lowered := strings.ToLower(password)
+		localPart := strings.SplitN(email, "@", 2)[0]
+		if containsUserInfo(lowered, userName) {
+			violations = append(violations, "The password must not contain the user name")
+		}
+		if containsUserInfo(lowered, email) || containsUserInfo(lowered, localPart) {
+			violations = append(violations, "The password must not contain the email")
+		}
+	}
+	return violations
+}
+
+func containsUserInfo(loweredPassword, info string) bool {
+	return len(info) >= minUserInfoLength && strings.Contains(loweredPassword, strings.ToLower(info))
+}
+
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import "time"
+
+const (
+	defaultSessionIdleTimeout  = 7 * 24 * 3600
+	defaultSessionMaxLifetime  = 30 * 24 * 3600
+	defaultReaperInterval      = 600
+	defaultReaperBatchSize     = 500
+	defaultTokenCacheStaleness = 5
+)
+
+// SessionIdleTimeout returns how long a session can be unused before it expires
+func SessionIdleTimeout() time.Duration {
+	if configuration.SessionIdleTimeout > 0 {
+		return time.Duration(configuration.SessionIdleTimeout) * time.Second
+	}
+	return defaultSessionIdleTimeout * time.Second
+}
+
+// SessionMaxLifetime returns how long a session can last since it was created
+func SessionMaxLifetime() time.Duration {
+	if configuration.SessionMaxLifetime > 0 {
+		return time.Duration(configuration.SessionMaxLifetime) * time.Second
+	}
+	return defaultSessionMaxLifetime * time.Second
+}
+
+// ReaperInterval returns how often the expired sessions are deleted
+func ReaperInterval() time.Duration {
+	if configuration.ReaperInterval > 0 {
+		return time.Duration(configuration.ReaperInterval) * time.Second
+	}
+	return defaultReaperInterval * time.Second
+}
+
+// ReaperBatchSize returns how many expired sessions are deleted per transaction
+func ReaperBatchSize() int {
+	if configuration.ReaperBatchSize > 0 {
+		return configuration.ReaperBatchSize
+	}
+	return defaultReaperBatchSize
+}
+
+// TokenCacheSize returns how many valid tokens are cached. The cache is disabled by default.
+func TokenCacheSize() int {
+	return configuration.TokenCacheSize
+}
+
+// TokenCacheStaleness returns how long a cached token is trusted without checking its session
+func TokenCacheStaleness() time.Duration {
+	if configuration.TokenCacheStaleness > 0 {
+		return time.Duration(configuration.TokenCacheStaleness) * time.Second
+	}
+	return defaultTokenCacheStaleness * time.Second
+}
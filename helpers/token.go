@@ -1,42 +1,331 @@
 package helpers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-// Tokenize returns a token from a given text
-func Tokenize(id string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-		"id": id,
+const (
+	defaultAccessTokenLifetime  = 3600
+	defaultRefreshTokenLifetime = 30 * 24 * 3600
+	defaultClockSkew            = 30
+	defaultDenylistInterval     = 30
+	defaultIssuer               = "SessionManager"
+)
+
+const (
+	// TokenFormatJWT issues signed JWTs that can be verified without the session store
+	TokenFormatJWT = "jwt"
+	// TokenFormatOpaque issues random tokens that can only be resolved through the session store
+	TokenFormatOpaque = "opaque"
+)
+
+const (
+	// VerificationModeSession checks every token against its session in the database
+	VerificationModeSession = "session"
+	// VerificationModeStateless checks the signature and expiration of the tokens and
+	// only a denylist of revoked tokens refreshed periodically from the database
+	VerificationModeStateless = "stateless"
+)
+
+var (
+	// ErrTokenExpired is returned when a well signed token is no longer valid
+	ErrTokenExpired = errors.New("The token has expired")
+	// ErrTokenInvalid is returned when a token is malformed, forged or not valid yet
+	ErrTokenInvalid = errors.New("The token is invalid")
+	// ErrTokenAudience is returned when a token was issued for another audience
+	ErrTokenAudience = errors.New("The token is not valid for the audience")
+)
+
+// TokenClaims are the claims of the tokens issued by the server. ID is the user and
+// SessionID the session of the token, while the jti of StandardClaims identifies the token.
+type TokenClaims struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sid,omitempty"`
+	CustomClaims
+	jwt.StandardClaims
+}
+
+// Valid checks the time based claims allowing the configured clock skew
+func (c TokenClaims) Valid() error {
+	now := time.Now().Unix()
+	skew := int64(clockSkew() / time.Second)
+	vErr := new(jwt.ValidationError)
+
+	if c.ExpiresAt == 0 {
+		vErr.Inner = fmt.Errorf("token has no expiration date")
+		vErr.Errors |= jwt.ValidationErrorClaimsInvalid
+	} else if !c.VerifyExpiresAt(now-skew, true) {
+		vErr.Inner = fmt.Errorf("token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+
+	if !c.VerifyIssuedAt(now+skew, false) {
+		vErr.Inner = fmt.Errorf("token used before issued")
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+
+	if !c.VerifyNotBefore(now+skew, false) {
+		vErr.Inner = fmt.Errorf("token is not valid yet")
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if vErr.Errors == 0 {
+		return nil
+	}
+	return vErr
+}
+
+// AccessTokenLifetime returns how long a token can be used
+func AccessTokenLifetime() time.Duration {
+	if configuration.AccessTokenLifetime > 0 {
+		return time.Duration(configuration.AccessTokenLifetime) * time.Second
+	}
+	return defaultAccessTokenLifetime * time.Second
+}
+
+// RefreshTokenLifetime returns how long a refresh token can be used
+func RefreshTokenLifetime() time.Duration {
+	if configuration.RefreshTokenLifetime > 0 {
+		return time.Duration(configuration.RefreshTokenLifetime) * time.Second
+	}
+	return defaultRefreshTokenLifetime * time.Second
+}
+
+func clockSkew() time.Duration {
+	if configuration.ClockSkew > 0 {
+		return time.Duration(configuration.ClockSkew) * time.Second
+	}
+	return defaultClockSkew * time.Second
+}
+
+// Issuer returns the iss claim of the issued tokens
+func Issuer() string {
+	if configuration.Issuer != "" {
+		return configuration.Issuer
+	}
+	return defaultIssuer
+}
+
+// AudienceAllowed checks if tokens can be issued for the given audience. Any audience is
+// allowed unless Audiences are configured. Tokens without audience are always allowed.
+func AudienceAllowed(audience string) bool {
+	if audience == "" || len(configuration.Audiences) == 0 {
+		return true
+	}
+	for _, allowed := range configuration.Audiences {
+		if allowed == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenFormat returns the format of the issued tokens, TokenFormatJWT by default
+func TokenFormat() string {
+	if configuration.TokenFormat == TokenFormatOpaque {
+		return TokenFormatOpaque
+	}
+	return TokenFormatJWT
+}
+
+// VerificationMode returns how tokens are checked, VerificationModeSession by default
+func VerificationMode() string {
+	if configuration.VerificationMode == VerificationModeStateless {
+		return VerificationModeStateless
+	}
+	return VerificationModeSession
+}
+
+// DenylistInterval returns how often the denylist of revoked tokens is refreshed in stateless mode
+func DenylistInterval() time.Duration {
+	if configuration.DenylistInterval > 0 {
+		return time.Duration(configuration.DenylistInterval) * time.Second
+	}
+	return defaultDenylistInterval * time.Second
+}
+
+// IssueToken returns a new token for the given user, session and optional audience in the
+// configured TokenFormat. The custom claims are only embedded in JWTs.
+func IssueToken(userID, sessionID, audience string, claims CustomClaims) (string, error) {
+	if TokenFormat() == TokenFormatOpaque {
+		return RandomString(32)
+	}
+	return Tokenize(userID, sessionID, audience, claims)
+}
+
+// IsOpaqueToken checks if the given token is an opaque token instead of a JWT
+func IsOpaqueToken(token string) bool {
+	return strings.Count(token, ".") != 2
+}
+
+// Tokenize returns a JWT of the given user, session, optional audience and custom claims with a random jti
+func Tokenize(id, sessionID, audience string, claims CustomClaims) (string, error) {
+	key, err := getKeyring().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	jti, err := NewUUID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(key.Method, TokenClaims{
+		ID:           id,
+		SessionID:    sessionID,
+		CustomClaims: claims,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    Issuer(),
+			Audience:  audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(AccessTokenLifetime()).Unix(),
+		},
 	})
-	tokenString, err := token.SignedString([]byte("SecretKey"))
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.SignKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-// GetFromToken gets the value of a given token
+// GenerateRefreshToken returns a new random refresh token
+func GenerateRefreshToken() (string, error) {
+	return RandomString(32)
+}
+
+// HashToken returns the hex encoded SHA-256 of a given token, or its HMAC-SHA256
+// if a TokenHashKey is configured. Only the hashes of the tokens are stored.
+func HashToken(token string) string {
+	if configuration.TokenHashKey != "" {
+		mac := hmac.New(sha256.New, []byte(configuration.TokenHashKey))
+		mac.Write([]byte(token))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetFromToken gets the value of a given token. It returns ErrTokenExpired if the
+// token has expired and ErrTokenInvalid if it cannot be trusted.
 func GetFromToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.ID, nil
+}
 
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// GetClaimsFromToken returns the claims of the given JWT. It returns ErrTokenExpired if the
+// token has expired and ErrTokenInvalid if it cannot be trusted.
+func GetClaimsFromToken(tokenString string) (*TokenClaims, error) {
+	return parseToken(tokenString)
+}
+
+// TokenExpiresAt returns the expiration date of the given JWT without verifying it, so it
+// must only be used with tokens already verified. It returns false for opaque tokens.
+func TokenExpiresAt(tokenString string) (time.Time, bool) {
+	claims := unverifiedClaims(tokenString)
+	if claims == nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.ExpiresAt, 0), true
+}
+
+// TokenID returns the jti of the given JWT without verifying it, so it must only be used
+// with tokens already verified or issued by the server. It is empty for opaque tokens.
+func TokenID(tokenString string) string {
+	claims := unverifiedClaims(tokenString)
+	if claims == nil {
+		return ""
+	}
+	return claims.Id
+}
+
+// TokenAudience returns the aud of the given JWT without verifying it, so it must only be
+// used with tokens already verified or issued by the server. It is empty for opaque tokens.
+func TokenAudience(tokenString string) string {
+	claims := unverifiedClaims(tokenString)
+	if claims == nil {
+		return ""
+	}
+	return claims.Audience
+}
+
+// TokenSessionID returns the sid of the given JWT without verifying it, so it must only be
+// used with tokens already verified or issued by the server. It is empty for opaque tokens.
+func TokenSessionID(tokenString string) string {
+	claims := unverifiedClaims(tokenString)
+	if claims == nil {
+		return ""
+	}
+	return claims.SessionID
+}
+
+// TokenCustomClaims returns the custom claims of the given JWT without verifying it, so it
+// must only be used with tokens already verified. It returns false for opaque tokens.
+func TokenCustomClaims(tokenString string) (CustomClaims, bool) {
+	claims := unverifiedClaims(tokenString)
+	if claims == nil {
+		return CustomClaims{}, false
+	}
+	return claims.CustomClaims, true
+}
+
+func unverifiedClaims(tokenString string) *TokenClaims {
+	if IsOpaqueToken(tokenString) {
+		return nil
+	}
+	claims := new(TokenClaims)
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+func parseToken(tokenString string) (*TokenClaims, error) {
+	claims := new(TokenClaims)
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("The token has no key id")
+		}
+
+		key, err := getKeyring().VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return []byte("SecretKey"), nil
+		return key.VerifyKey, nil
 	})
 
 	if err != nil {
-		return "", err
+		if vErr, ok := err.(*jwt.ValidationError); ok && vErr.Errors == jwt.ValidationErrorExpired {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrTokenInvalid
+	}
+
+	if !token.Valid || claims.ID == "" {
+		return nil, ErrTokenInvalid
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["id"].(string), nil
+	if claims.Issuer != Issuer() {
+		return nil, ErrTokenInvalid
 	}
 
-	return "", err
+	return claims, nil
 }
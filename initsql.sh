@@ -7,9 +7,18 @@ else
 fi
 
 echo "Seting up database on $DOCKERIP..."
-mysql -uroot -pmypassword -h $DOCKERIP -P 3306 < data/sessionmanager.sql
+if [ "$1" = "-m" ]; then
+  echo "Upgrading the baseline database..."
+  mysql -uroot -pmypassword -h $DOCKERIP -P 3306 < data/baseline.sql
+  for MIGRATION in data/migrations/*.sql; do
+    echo "Executing $MIGRATION"
+    mysql -uroot -pmypassword -h $DOCKERIP -P 3306 < $MIGRATION || exit 1
+  done
+else
+  mysql -uroot -pmypassword -h $DOCKERIP -P 3306 < data/sessionmanager.sql
+fi
 if ! [ -z "$1" ]; then
-  if [ $1 = "-t" ]; then
+  if [ $1 = "-t" ] || [ $1 = "-m" ]; then
     echo "Testing database..."
     cd mytap
     mysql -uroot -pmypassword -h $DOCKERIP -P 3306 < mytap.sql
@@ -28,11 +37,16 @@ if [ -e configuration/configuration.json ]; then
     rm configuration/configuration.json
 fi
 
+TOKENKEYID=$(date +%Y%m%d%H%M%S)
+TOKENSECRET=$(head -c 64 /dev/urandom | od -An -tx1 | tr -d ' \n')
+
 if [ $DOCKERIP = "127.0.0.1" ]; then
   echo '{
     "ip":"'$DOCKERIP'",
     "port":3306,
-    "ConnString":"root:mypassword@/sessionmanager"
+    "ConnString":"root:mypassword@/sessionmanager",
+    "SigningKeyID":"'$TOKENKEYID'",
+    "TokenKeys":[{"ID":"'$TOKENKEYID'","Secret":"'$TOKENSECRET'"}]
   }' >> configuration/configuration.json
   echo "configuration.json file created"
   cat configuration/configuration.json
@@ -40,7 +54,9 @@ else
   echo '{
     "ip":"'$DOCKERIP'",
     "port":3306,
-    "ConnString":"root:mypassword@('$DOCKERIP':3306)/sessionmanager"
+    "ConnString":"root:mypassword@('$DOCKERIP':3306)/sessionmanager",
+    "SigningKeyID":"'$TOKENKEYID'",
+    "TokenKeys":[{"ID":"'$TOKENKEYID'","Secret":"'$TOKENSECRET'"}]
   }' >> configuration/configuration.json
   echo "configuration.json file created"
   cat configuration/configuration.json
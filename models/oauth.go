@@ -0,0 +1,29 @@
+package models
+
+const (
+	// AccessTokenHint is the token_type_hint of access tokens as described in RFC 7009
+	AccessTokenHint = "access_token"
+	// RefreshTokenHint is the token_type_hint of refresh tokens as described in RFC 7009
+	RefreshTokenHint = "refresh_token"
+)
+
+// Introspection represents the state of a token as described in RFC 7662.
+// Inactive tokens only have Active set.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// OAuthError represents an error of the OAuth endpoints as described in RFC 6749
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
@@ -7,8 +7,14 @@ type ResponseData struct {
 
 // Response to client
 type Response struct {
-	Status      int    `json:"Status"` //httpstatus
-	Error       int    `json:"Error"`  //-1: unknown, -2: ecxeption, 1:ok, there are no 0's
-	Description string `json:"Description"`
-	Token       string `json:"Token"`
+	Status       int                    `json:"Status"` //httpstatus
+	Error        int                    `json:"Error"`  //-1: unknown, -2: ecxeption, 1:ok, there are no 0's
+	Description  string                 `json:"Description"`
+	Token        string                 `json:"Token"`
+	RefreshToken string                 `json:"RefreshToken,omitempty"`
+	Sessions     []Session              `json:"Sessions,omitempty"`
+	CSRFToken    string                 `json:"CSRFToken,omitempty"`
+	Fields       map[string][]string    `json:"Fields,omitempty"`
+	Roles        []string               `json:"Roles,omitempty"`
+	Claims       map[string]interface{} `json:"Claims,omitempty"`
 }
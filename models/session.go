@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Client represents the device that opens a session. ClientID, Scope and Audience
+// are the optional application, scope and audience the session was opened for.
+type Client struct {
+	IP         string `json:"IP"`
+	UserAgent  string `json:"UserAgent"`
+	DeviceName string `json:"DeviceName"`
+	ClientID   string `json:"ClientID,omitempty"`
+	Scope      string `json:"Scope,omitempty"`
+	Audience   string `json:"Audience,omitempty"`
+}
+
+// Session represents a logged in device of a user
+type Session struct {
+	ID string `json:"ID"`
+	Client
+	LastIP       string    `json:"LastIP"`
+	DateCreated  time.Time `json:"DateCreated"`
+	LastDateUsed time.Time `json:"LastDateUsed"`
+	Current      bool      `json:"Current"`
+}
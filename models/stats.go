@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Stats represents the counters of the background jobs and caches of the server.
+// TokenCache is only set when the cache of tokens is enabled.
+type Stats struct {
+	Reaper     ReaperStats      `json:"Reaper"`
+	TokenCache *TokenCacheStats `json:"TokenCache,omitempty"`
+}
+
+// ReaperStats represents the results of the last run of the reaper of expired sessions
+type ReaperStats struct {
+	LastRun              time.Time `json:"LastRun"`
+	Duration             string    `json:"Duration"`
+	Batches              int       `json:"Batches"`
+	SessionsDeleted      int64     `json:"SessionsDeleted"`
+	RefreshTokensDeleted int64     `json:"RefreshTokensDeleted"`
+	RevokedTokensDeleted int64     `json:"RevokedTokensDeleted"`
+	Error                string    `json:"Error,omitempty"`
+}
+
+// TokenCacheStats represents the hits and misses of the cache of tokens and how many tokens it holds
+type TokenCacheStats struct {
+	Hits   uint64 `json:"Hits"`
+	Misses uint64 `json:"Misses"`
+	Size   int    `json:"Size"`
+}
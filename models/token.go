@@ -0,0 +1,6 @@
+package models
+
+// RefreshRequest represents the body of a token refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"RefreshToken"`
+}
@@ -6,3 +6,25 @@ type User struct {
 	Email    string `json:"Email"`
 	Password string `json:"Password"`
 }
+
+// ImportedUser represents a user imported from another system along with its password hash
+type ImportedUser struct {
+	UserName     string
+	Email        string
+	PasswordHash string
+}
+
+// PasswordChangeRequest represents the current and new passwords sent to change them
+type PasswordChangeRequest struct {
+	OldPassword string `json:"OldPassword"`
+	NewPassword string `json:"NewPassword"`
+}
+
+// LoginRequest represents the credentials sent to log in
+type LoginRequest struct {
+	User
+	DeviceName string `json:"DeviceName"`
+	ClientID   string `json:"ClientID"`
+	Scope      string `json:"Scope"`
+	Audience   string `json:"Audience"`
+}
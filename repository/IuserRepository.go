@@ -8,9 +8,19 @@ import (
 type IUserRepositoryInterface interface {
 	Register(user models.User) error
 	GetIDAndPassword(userName string) (string, string, error)
-	CreateToken(userID, token string) error
-	DeleteToken(userID, token string) error
+	GetNameEmailAndPassword(userID string) (string, string, string, error)
+	GetRoles(userID string) ([]string, error)
+	UpdatePassword(userID, oldHash, newHash string) (bool, error)
+	CreateToken(sessionID, userID, token, refreshToken string, client models.Client) error
+	DeleteToken(token string) error
 	ExistsUsername(userName string) (bool, error)
 	ExistsEmail(email string) (bool, error)
-	CheckToken(token string) (bool, error)
+	CheckToken(token, ip, audience string) (string, error)
+	GetRefreshTokenSession(refreshToken string) (string, models.Session, error)
+	RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error
+	GetSessions(userID, currentToken string) ([]models.Session, error)
+	DeleteSession(userID, sessionID string) (bool, error)
+	DeleteSessions(userID string) error
+	IntrospectToken(token string) (models.Introspection, error)
+	RevokeToken(token, tokenTypeHint string) error
 }
@@ -1,15 +1,55 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/44r0n/SessionManager/data"
 	"github.com/44r0n/SessionManager/helpers"
 	"github.com/44r0n/SessionManager/models"
+)
 
-	"golang.org/x/crypto/bcrypt"
+var (
+	// ErrRefreshTokenInvalid is returned when a refresh token does not exist or has expired
+	ErrRefreshTokenInvalid = errors.New("The refresh token is invalid")
+	// ErrRefreshTokenReused is returned when an already used refresh token is presented again
+	ErrRefreshTokenReused = errors.New("The refresh token was already used")
+	// ErrSessionExpired is returned when a session has been idle too long or has reached its maximum lifetime
+	ErrSessionExpired = errors.New("The session has expired")
 )
 
+// activeSession is the condition of the sessions of user_tokens that have not expired. Use it with activeSessionArgs.
+const activeSession = "user_tokens.last_date_used > NOW() - INTERVAL ? SECOND AND user_tokens.date_created > NOW() - INTERVAL ? SECOND"
+
+func activeSessionArgs() []interface{} {
+	return []interface{}{lifetimeSeconds(helpers.SessionIdleTimeout()), lifetimeSeconds(helpers.SessionMaxLifetime())}
+}
+
+// lifetimeSeconds converts a lifetime for the INTERVAL ? SECOND of the queries. Dates are
+// always computed by the database, since the driver does not convert the time zones of DATETIME.
+func lifetimeSeconds(lifetime time.Duration) int64 {
+	return int64(lifetime / time.Second)
+}
+
+// tokenSession returns the condition of user_tokens matching the session of the given token:
+// the sid and jti of a JWT, so only the current token of the session matches, or the hash of
+// an opaque token.
+func tokenSession(token string) (string, []interface{}) {
+	if sessionID, tokenID := helpers.TokenSessionID(token), helpers.TokenID(token); sessionID != "" && tokenID != "" {
+		return "id = ? AND token_id = ?", []interface{}{sessionID, tokenID}
+	}
+	return "token_hash = ?", []interface{}{helpers.HashToken(token)}
+}
+
+// revokeTokens adds the current JWTs of the sessions of user_tokens matching the given
+// condition to the denylist of revoked tokens. Call it before deleting or replacing them.
+func revokeTokens(tx *sql.Tx, condition string, args ...interface{}) error {
+	_, err := tx.Exec("INSERT IGNORE INTO revoked_tokens (jti, expires, date_revoked) SELECT token_id, token_expires, NOW() from user_tokens where token_id IS NOT NULL AND token_expires > NOW() AND "+condition, args...)
+	return err
+}
+
 // UserRepository struct implementation of IUserRepositoryInterface
 type UserRepository struct {
 	mysqlconnString string
@@ -26,8 +66,7 @@ func NewUserRepository(connString string) (*UserRepository, error) {
 
 // Register function that registers the given user.
 func (usr *UserRepository) Register(user models.User) error {
-	password := []byte(user.Password)
-	hashedPass, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	hashedPass, err := helpers.GenerateHash(user.Password)
 	if err != nil {
 		return err
 	}
@@ -38,6 +77,37 @@ func (usr *UserRepository) Register(user models.User) error {
 	return nil
 }
 
+// ImportUsers registers the given users storing their password hashes verbatim, so they
+// must be checkable by helpers.CheckHash. Users whose username or email already exist are
+// skipped. It returns how many users were imported.
+func (usr *UserRepository) ImportUsers(users []models.ImportedUser) (int64, error) {
+	var imported int64
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	err := datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO users (id,username,email,password,date_created) SELECT uuid(),?,?,?,NOW() FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM users where username = ? OR email = ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, user := range users {
+			result, err := stmt.Exec(user.UserName, user.Email, user.PasswordHash, user.UserName, user.Email)
+			if err != nil {
+				return err
+			}
+			inserted, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			imported += inserted
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
 // ExistsUsername function checks if the given userName exists
 func (usr *UserRepository) ExistsUsername(userName string) (bool, error) {
 	datab := database.NewDatabaseConnection(usr.mysqlconnString)
@@ -70,6 +140,37 @@ func (usr *UserRepository) ExistsEmail(email string) (bool, error) {
 	return false, nil
 }
 
+// UpdatePassword replaces the password hash of the given user only if it is still oldHash, so a
+// password changed meanwhile is never overwritten. It returns false if the hash was not replaced.
+func (usr *UserRepository) UpdatePassword(userID, oldHash, newHash string) (bool, error) {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	updated, err := datab.ExecuteNonQueryCount("UPDATE users SET password = ? where id = ? AND BINARY password = ?", newHash, userID, oldHash)
+	if err != nil {
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+// GetRoles returns the roles of the given user sorted by name
+func (usr *UserRepository) GetRoles(userID string) ([]string, error) {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery("SELECT role from user_roles where user = ? ORDER BY role", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
 // GetIDAndPassword from a given userName
 func (usr *UserRepository) GetIDAndPassword(userName string) (string, string, error) {
 	datab := database.NewDatabaseConnection(usr.mysqlconnString)
@@ -88,42 +189,360 @@ func (usr *UserRepository) GetIDAndPassword(userName string) (string, string, er
 
 }
 
-//CreateToken from a given userID and token
-func (usr *UserRepository) CreateToken(userID, token string) error {
+// GetNameEmailAndPassword from a given userID
+func (usr *UserRepository) GetNameEmailAndPassword(userID string) (string, string, string, error) {
 	datab := database.NewDatabaseConnection(usr.mysqlconnString)
-	if err := datab.ExecuteNonQuery("INSERT INTO user_tokens (user, token, last_date_used) VALUES(?,?,NOW())", userID, token); err != nil {
-		return err
+	rows, err := datab.ExecuteQuery("SELECT username, email, password from users where id = ? LIMIT 1", userID)
+	if err != nil {
+		return "", "", "", err
 	}
-	return nil
+	defer rows.Close()
+
+	var userName, email, storedPassword string
+	if !rows.Next() {
+		return "", "", "", rows.Err()
+	}
+	if err := rows.Scan(&userName, &email, &storedPassword); err != nil {
+		return "", "", "", err
+	}
+	return userName, email, storedPassword, nil
+}
+
+//CreateToken creates the session sessionID of the given userID and client with its token and refreshToken
+func (usr *UserRepository) CreateToken(sessionID, userID, token, refreshToken string, client models.Client) error {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	return datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO user_tokens (id, user, token_hash, token_id, token_issued, token_expires, ip, user_agent, device_name, last_ip, client_id, scope, audience, date_created, last_date_used) VALUES(?,?,?,?,NOW(),NOW() + INTERVAL ? SECOND,?,?,?,?,?,?,?,NOW(),NOW())",
+			sessionID, userID, helpers.HashToken(token), nullIfEmpty(helpers.TokenID(token)), lifetimeSeconds(helpers.AccessTokenLifetime()), client.IP, truncate(client.UserAgent, 255), truncate(client.DeviceName, 165), client.IP,
+			nullIfEmpty(truncate(client.ClientID, 165)), nullIfEmpty(truncate(client.Scope, 255)), nullIfEmpty(truncate(client.Audience, 165))); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO refresh_tokens (token_hash, user, session, date_created, expires) VALUES(?,?,?,NOW(),NOW() + INTERVAL ? SECOND)", helpers.HashToken(refreshToken), userID, sessionID, lifetimeSeconds(helpers.RefreshTokenLifetime()))
+		return err
+	})
 }
 
-// DeleteToken from a given userID and token
-func (usr *UserRepository) DeleteToken(userID, token string) error {
+// DeleteToken deletes the session of the given token. Its refresh tokens are deleted too.
+func (usr *UserRepository) DeleteToken(token string) error {
 	datab := database.NewDatabaseConnection(usr.mysqlconnString)
-	if err := datab.ExecuteNonQuery("DELETE FROM user_tokens where user = ? and token = ?", userID, token); err != nil {
+	condition, args := tokenSession(token)
+	return datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		if err := revokeTokens(tx, condition, args...); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM user_tokens where "+condition, args...)
 		return err
+	})
+}
+
+// GetSessions returns the sessions of the given userID, the one of currentToken is marked as current
+func (usr *UserRepository) GetSessions(userID, currentToken string) ([]models.Session, error) {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery("SELECT id, COALESCE(ip, ''), COALESCE(user_agent, ''), COALESCE(device_name, ''), COALESCE(last_ip, ''), COALESCE(client_id, ''), COALESCE(scope, ''), COALESCE(audience, ''), UNIX_TIMESTAMP(date_created), UNIX_TIMESTAMP(last_date_used), token_hash = ? from user_tokens where user = ? AND "+activeSession+" ORDER BY last_date_used DESC",
+		append([]interface{}{helpers.HashToken(currentToken), userID}, activeSessionArgs()...)...)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		var created, lastUsed int64
+		if err := rows.Scan(&session.ID, &session.IP, &session.UserAgent, &session.DeviceName, &session.LastIP, &session.ClientID, &session.Scope, &session.Audience, &created, &lastUsed, &session.Current); err != nil {
+			return nil, err
+		}
+		session.DateCreated = time.Unix(created, 0).UTC()
+		session.LastDateUsed = time.Unix(lastUsed, 0).UTC()
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
 }
 
-// CheckToken function
-func (usr *UserRepository) CheckToken(token string) (bool, error) {
-	user, err := helpers.GetFromToken(token)
+// DeleteSession deletes the given session of userID. It returns false if the session does not exist.
+func (usr *UserRepository) DeleteSession(userID, sessionID string) (bool, error) {
+	var deleted int64
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	err := datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		if err := revokeTokens(tx, "id = ? AND user = ?", sessionID, userID); err != nil {
+			return err
+		}
+		result, err := tx.Exec("DELETE FROM user_tokens where id = ? AND user = ?", sessionID, userID)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
+	return deleted > 0, nil
+}
+
+// DeleteSessions deletes all the sessions of the given userID
+func (usr *UserRepository) DeleteSessions(userID string) error {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	return datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		if err := revokeTokens(tx, "user = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM user_tokens where user = ?", userID)
+		return err
+	})
+}
 
+// DeleteExpiredSessions deletes at most batchSize expired sessions along with their refresh tokens
+func (usr *UserRepository) DeleteExpiredSessions(batchSize int) (int64, error) {
 	datab := database.NewDatabaseConnection(usr.mysqlconnString)
-	rows, err := datab.ExecuteQuery("SELECT user from user_tokens where user = ? AND token = ?", user, token)
+	return datab.ExecuteNonQueryCount("DELETE FROM user_tokens where NOT ("+activeSession+") LIMIT ?",
+		append(activeSessionArgs(), batchSize)...)
+}
+
+// DeleteExpiredRevokedTokens deletes at most batchSize revoked tokens that have expired anyway
+func (usr *UserRepository) DeleteExpiredRevokedTokens(batchSize int) (int64, error) {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	return datab.ExecuteNonQueryCount("DELETE FROM revoked_tokens where expires <= NOW() LIMIT ?", batchSize)
+}
+
+// GetRevokedTokens returns the ids of the revoked tokens that have not expired yet and were
+// revoked since the given date, or all of them if it is zero, along with their expiration dates
+func (usr *UserRepository) GetRevokedTokens(since time.Time) ([]RevokedToken, error) {
+	query := "SELECT jti, UNIX_TIMESTAMP(expires), UNIX_TIMESTAMP(date_revoked) from revoked_tokens where expires > NOW()"
+	var args []interface{}
+	if !since.IsZero() {
+		query += " AND date_revoked >= FROM_UNIXTIME(?)"
+		args = append(args, since.Unix())
+	}
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery(query, args...)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := []RevokedToken{}
+	for rows.Next() {
+		var token RevokedToken
+		var expires, dateRevoked int64
+		if err := rows.Scan(&token.ID, &expires, &dateRevoked); err != nil {
+			return nil, err
+		}
+		token.Expires = time.Unix(expires, 0)
+		token.DateRevoked = time.Unix(dateRevoked, 0)
+		revoked = append(revoked, token)
+	}
+	return revoked, rows.Err()
+}
+
+// DeleteExpiredRefreshTokens deletes at most batchSize expired refresh tokens
+func (usr *UserRepository) DeleteExpiredRefreshTokens(batchSize int) (int64, error) {
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	return datab.ExecuteNonQueryCount("DELETE FROM refresh_tokens where expires <= NOW() LIMIT ?", batchSize)
+}
+
+// GetRefreshTokenSession returns the user and session of a given refresh token whose session has not expired.
+// Only the id, client id, scope and audience of the session are set.
+func (usr *UserRepository) GetRefreshTokenSession(refreshToken string) (string, models.Session, error) {
+	var session models.Session
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery("SELECT refresh_tokens.user, refresh_tokens.session, COALESCE(user_tokens.client_id, ''), COALESCE(user_tokens.scope, ''), COALESCE(user_tokens.audience, '') from refresh_tokens JOIN user_tokens ON refresh_tokens.session = user_tokens.id where refresh_tokens.token_hash = ? AND refresh_tokens.expires > NOW() AND "+activeSession+" LIMIT 1",
+		append([]interface{}{helpers.HashToken(refreshToken)}, activeSessionArgs()...)...)
+	if err != nil {
+		return "", session, err
 	}
 	var idChecker string
 	rows.Next()
-	rows.Scan(&idChecker)
+	rows.Scan(&idChecker, &session.ID, &session.ClientID, &session.Scope, &session.Audience)
+	rows.Close()
 	if idChecker == "" {
-		return false, nil
+		return "", models.Session{}, ErrRefreshTokenInvalid
 	}
+	return idChecker, session, nil
+}
+
+// RotateRefreshToken exchanges a given refreshToken for a new token and refresh token of
+// the same session. If the refreshToken was already used the whole session is revoked.
+func (usr *UserRepository) RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error {
+	reused := false
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	err := datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		var user, session string
+		var used bool
+		err := tx.QueryRow("SELECT refresh_tokens.user, refresh_tokens.session, refresh_tokens.used from refresh_tokens JOIN user_tokens ON refresh_tokens.session = user_tokens.id where refresh_tokens.token_hash = ? AND refresh_tokens.expires > NOW() AND "+activeSession+" FOR UPDATE",
+			append([]interface{}{helpers.HashToken(refreshToken)}, activeSessionArgs()...)...).Scan(&user, &session, &used)
+		if err == sql.ErrNoRows {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := revokeTokens(tx, "id = ?", session); err != nil {
+			return err
+		}
+
+		if used {
+			reused = true
+			_, err := tx.Exec("DELETE FROM user_tokens where id = ?", session)
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE refresh_tokens SET used = 1 where token_hash = ?", helpers.HashToken(refreshToken)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO refresh_tokens (token_hash, user, session, date_created, expires) VALUES(?,?,?,NOW(),NOW() + INTERVAL ? SECOND)", helpers.HashToken(newRefreshToken), user, session, lifetimeSeconds(helpers.RefreshTokenLifetime())); err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE user_tokens SET token_hash = ?, token_id = ?, token_issued = NOW(), token_expires = NOW() + INTERVAL ? SECOND, last_date_used = NOW() where id = ?",
+			helpers.HashToken(newToken), nullIfEmpty(helpers.TokenID(newToken)), lifetimeSeconds(helpers.AccessTokenLifetime()), session)
+		return err
+	})
 
-	return true, nil
+	if err != nil {
+		return err
+	}
+	if reused {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// CheckToken checks the given JWT or opaque token, updates the last use of its session
+// from the given ip and returns its user. It returns an empty user if there is no session
+// of the token and ErrSessionExpired if the session has been idle too long or is too old.
+// If an audience is given, it returns helpers.ErrTokenAudience for tokens of other audiences.
+func (usr *UserRepository) CheckToken(token, ip, audience string) (string, error) {
+	if !helpers.IsOpaqueToken(token) {
+		if _, err := helpers.GetFromToken(token); err != nil {
+			return "", err
+		}
+	}
+
+	condition, args := tokenSession(token)
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery("SELECT id, user, token_expires > NOW(), "+activeSession+", COALESCE(audience, '') from user_tokens where "+condition,
+		append(activeSessionArgs(), args...)...)
+	if err != nil {
+		return "", err
+	}
+	var sessionID, user, tokenAudience string
+	var current, active bool
+	rows.Next()
+	rows.Scan(&sessionID, &user, &current, &active, &tokenAudience)
+	rows.Close()
+	if sessionID == "" {
+		return "", nil
+	}
+	if !active {
+		return "", ErrSessionExpired
+	}
+	// JWTs carry their own expiration, already checked allowing the clock skew
+	if !current && helpers.IsOpaqueToken(token) {
+		return "", helpers.ErrTokenExpired
+	}
+	if audience != "" && audience != tokenAudience {
+		return "", helpers.ErrTokenAudience
+	}
+
+	if err := datab.ExecuteNonQuery("UPDATE user_tokens SET last_date_used = NOW(), last_ip = ? where id = ?", ip, sessionID); err != nil {
+		return "", err
+	}
+
+	return user, nil
+}
+
+// IntrospectToken returns the state of the given JWT or opaque token as described in RFC 7662.
+// Tokens that are invalid, expired or whose session has expired are not active. Like CheckToken,
+// introspecting an active token extends its session.
+func (usr *UserRepository) IntrospectToken(token string) (models.Introspection, error) {
+	inactive := models.Introspection{Active: false}
+	if !helpers.IsOpaqueToken(token) {
+		if _, err := helpers.GetFromToken(token); err != nil {
+			return inactive, nil
+		}
+	}
+
+	condition, args := tokenSession(token)
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	rows, err := datab.ExecuteQuery("SELECT id, user, UNIX_TIMESTAMP(token_issued), UNIX_TIMESTAMP(token_expires), token_expires > NOW(), "+activeSession+", COALESCE(client_id, ''), COALESCE(scope, ''), COALESCE(audience, '') from user_tokens where "+condition,
+		append(activeSessionArgs(), args...)...)
+	if err != nil {
+		return inactive, err
+	}
+	var introspection models.Introspection
+	var current, active bool
+	rows.Next()
+	rows.Scan(&introspection.SessionID, &introspection.Sub, &introspection.Iat, &introspection.Exp, &current, &active, &introspection.ClientID, &introspection.Scope, &introspection.Aud)
+	rows.Close()
+	if introspection.SessionID == "" || !active {
+		return inactive, nil
+	}
+	// JWTs carry their own expiration, already checked allowing the clock skew
+	if !current && helpers.IsOpaqueToken(token) {
+		return inactive, nil
+	}
+
+	if err := datab.ExecuteNonQuery("UPDATE user_tokens SET last_date_used = NOW() where id = ?", introspection.SessionID); err != nil {
+		return inactive, err
+	}
+
+	introspection.Active = true
+	introspection.TokenType = "Bearer"
+	introspection.Iss = helpers.Issuer()
+	return introspection, nil
+}
+
+// RevokeToken deletes the session of the given access or refresh token. The tokenTypeHint,
+// models.AccessTokenHint or models.RefreshTokenHint, only decides which kind is looked up first.
+// Unknown tokens are ignored.
+func (usr *UserRepository) RevokeToken(token, tokenTypeHint string) error {
+	condition, args := tokenSession(token)
+	lookups := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"SELECT id from user_tokens where " + condition, args},
+		{"SELECT session from refresh_tokens where token_hash = ?", []interface{}{helpers.HashToken(token)}},
+	}
+	if tokenTypeHint == models.RefreshTokenHint {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	datab := database.NewDatabaseConnection(usr.mysqlconnString)
+	return datab.ExecuteInTransaction(func(tx *sql.Tx) error {
+		for _, lookup := range lookups {
+			var session string
+			err := tx.QueryRow(lookup.query, lookup.args...).Scan(&session)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := revokeTokens(tx, "id = ?", session); err != nil {
+				return err
+			}
+			_, err = tx.Exec("DELETE FROM user_tokens where id = ?", session)
+			return err
+		}
+		return nil
+	})
+}
+
+// nullIfEmpty stores empty optional texts as NULL
+func nullIfEmpty(text string) interface{} {
+	if text == "" {
+		return nil
+	}
+	return text
+}
+
+// truncate cuts text to the given number of characters
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return text
 }
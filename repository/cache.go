@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/44r0n/SessionManager/helpers"
+)
+
+// TokenCacheStats are the counters of a CachedUserRepository
+type TokenCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+// CachedUserRepository is an IUserRepositoryInterface that caches the valid tokens
+// checked by CheckToken in a bounded LRU, so most checks neither parse the token nor
+// query the database. A cached token is trusted for at most maxStaleness and never
+// after it expires. Logging out, revoking sessions or reusing a refresh token removes
+// their tokens at once, but a token replaced by a refresh can still be accepted for up
+// to maxStaleness. Cached checks do not update the last use of the sessions, so it lags
+// by up to maxStaleness, which must be much shorter than the idle timeout of the sessions.
+type CachedUserRepository struct {
+	// hits and misses are first to be 64 bit aligned for the atomic operations
+	hits   uint64
+	misses uint64
+
+	IUserRepositoryInterface
+	size         int
+	maxStaleness time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// tokenCacheEntry is a cached valid token. The audience of opaque tokens is only known
+// once they have been checked for one.
+type tokenCacheEntry struct {
+	key           string
+	userID        string
+	ip            string
+	audience      string
+	audienceKnown bool
+	expires       time.Time
+}
+
+// NewCachedUserRepository creates a CachedUserRepository of at most size tokens in front of repo
+func NewCachedUserRepository(repo IUserRepositoryInterface, size int, maxStaleness time.Duration) *CachedUserRepository {
+	return &CachedUserRepository{
+		IUserRepositoryInterface: repo,
+		size:                     size,
+		maxStaleness:             maxStaleness,
+		entries:                  make(map[string]*list.Element),
+		lru:                      list.New(),
+	}
+}
+
+// CheckToken returns the user of the given token from the cache, or checks it with the
+// repository and caches it if it is valid. Cached checks do not update the last use of the
+// session, but tokens used from another ip are checked again to update its last ip.
+func (cur *CachedUserRepository) CheckToken(token, ip, audience string) (string, error) {
+	key := helpers.HashToken(token)
+	if entry, ok := cur.get(key); ok && entry.ip == ip && (audience == "" || entry.audienceKnown) {
+		atomic.AddUint64(&cur.hits, 1)
+		if audience != "" && audience != entry.audience {
+			return "", helpers.ErrTokenAudience
+		}
+		return entry.userID, nil
+	}
+	atomic.AddUint64(&cur.misses, 1)
+
+	userID, err := cur.IUserRepositoryInterface.CheckToken(token, ip, audience)
+	if err != nil || userID == "" {
+		return userID, err
+	}
+
+	entry := &tokenCacheEntry{key: key, userID: userID, ip: ip, expires: time.Now().Add(cur.maxStaleness)}
+	if tokenExpires, ok := helpers.TokenExpiresAt(token); ok && tokenExpires.Before(entry.expires) {
+		entry.expires = tokenExpires
+	}
+	if !helpers.IsOpaqueToken(token) {
+		entry.audience, entry.audienceKnown = helpers.TokenAudience(token), true
+	} else if audience != "" {
+		entry.audience, entry.audienceKnown = audience, true
+	}
+	cur.add(entry)
+	return userID, nil
+}
+
+// DeleteToken deletes the session of the given token and removes the token from the cache
+func (cur *CachedUserRepository) DeleteToken(token string) error {
+	err := cur.IUserRepositoryInterface.DeleteToken(token)
+	cur.remove(helpers.HashToken(token))
+	return err
+}
+
+// DeleteSession deletes the given session of userID and removes the tokens of userID from the cache
+func (cur *CachedUserRepository) DeleteSession(userID, sessionID string) (bool, error) {
+	deleted, err := cur.IUserRepositoryInterface.DeleteSession(userID, sessionID)
+	cur.removeUser(userID)
+	return deleted, err
+}
+
+// DeleteSessions deletes the sessions of userID and removes its tokens from the cache
+func (cur *CachedUserRepository) DeleteSessions(userID string) error {
+	err := cur.IUserRepositoryInterface.DeleteSessions(userID)
+	cur.removeUser(userID)
+	return err
+}
+
+// RotateRefreshToken exchanges a given refreshToken for a new token and refresh token. If
+// the refreshToken was reused, the tokens of its user are removed from the cache.
+func (cur *CachedUserRepository) RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error {
+	userID, userErr := helpers.GetFromToken(newToken)
+	if userErr != nil || userID == "" {
+		userID = cur.refreshTokenUser(refreshToken)
+	}
+	err := cur.IUserRepositoryInterface.RotateRefreshToken(refreshToken, newToken, newRefreshToken)
+	if err == ErrRefreshTokenReused && userID != "" {
+		cur.removeUser(userID)
+	}
+	return err
+}
+
+// RevokeToken revokes the session of the given access or refresh token and removes the
+// token from the cache, or the tokens of its user if it is a refresh token
+func (cur *CachedUserRepository) RevokeToken(token, tokenTypeHint string) error {
+	key := helpers.HashToken(token)
+	userID := ""
+	if _, cached := cur.get(key); !cached && helpers.IsOpaqueToken(token) {
+		userID = cur.refreshTokenUser(token)
+	}
+	err := cur.IUserRepositoryInterface.RevokeToken(token, tokenTypeHint)
+	cur.remove(key)
+	if userID != "" {
+		cur.removeUser(userID)
+	}
+	return err
+}
+
+// refreshTokenUser returns the user of the active session of the given refresh token, or
+// an empty user if it is not one
+func (cur *CachedUserRepository) refreshTokenUser(refreshToken string) string {
+	userID, _, err := cur.IUserRepositoryInterface.GetRefreshTokenSession(refreshToken)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// Purge removes every token from the cache
+func (cur *CachedUserRepository) Purge() {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	cur.entries = make(map[string]*list.Element)
+	cur.lru.Init()
+}
+
+// Stats returns the hits and misses of the cache and how many tokens it holds
+func (cur *CachedUserRepository) Stats() TokenCacheStats {
+	cur.mu.Lock()
+	size := cur.lru.Len()
+	cur.mu.Unlock()
+	return TokenCacheStats{
+		Hits:   atomic.LoadUint64(&cur.hits),
+		Misses: atomic.LoadUint64(&cur.misses),
+		Size:   size,
+	}
+}
+
+func (cur *CachedUserRepository) get(key string) (tokenCacheEntry, bool) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	element, ok := cur.entries[key]
+	if !ok {
+		return tokenCacheEntry{}, false
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.expires) {
+		cur.lru.Remove(element)
+		delete(cur.entries, key)
+		return tokenCacheEntry{}, false
+	}
+	cur.lru.MoveToFront(element)
+	return *entry, true
+}
+
+func (cur *CachedUserRepository) add(entry *tokenCacheEntry) {
+	if cur.size <= 0 {
+		return
+	}
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	if element, ok := cur.entries[entry.key]; ok {
+		element.Value = entry
+		cur.lru.MoveToFront(element)
+		return
+	}
+	cur.entries[entry.key] = cur.lru.PushFront(entry)
+	for cur.lru.Len() > cur.size {
+		oldest := cur.lru.Back()
+		cur.lru.Remove(oldest)
+		delete(cur.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+func (cur *CachedUserRepository) remove(key string) bool {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	element, ok := cur.entries[key]
+	if !ok {
+		return false
+	}
+	cur.lru.Remove(element)
+	delete(cur.entries, key)
+	return true
+}
+
+func (cur *CachedUserRepository) removeUser(userID string) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	for element := cur.lru.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*tokenCacheEntry)
+		if entry.userID == userID {
+			cur.lru.Remove(element)
+			delete(cur.entries, entry.key)
+		}
+		element = next
+	}
+}
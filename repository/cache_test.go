@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/44r0n/SessionManager/helpers"
+	"github.com/44r0n/SessionManager/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type checkTokenTest struct {
+	IUserRepositoryInterface
+	users     map[string]string
+	checks    int
+	rotateErr error
+}
+
+func (ctt *checkTokenTest) CheckToken(token, ip, audience string) (string, error) {
+	ctt.checks++
+	return ctt.users[token], nil
+}
+
+func (ctt *checkTokenTest) DeleteToken(token string) error {
+	delete(ctt.users, token)
+	return nil
+}
+
+func (ctt *checkTokenTest) DeleteSession(userID, sessionID string) (bool, error) {
+	return true, nil
+}
+
+func (ctt *checkTokenTest) DeleteSessions(userID string) error {
+	for token, user := range ctt.users {
+		if user == userID {
+			delete(ctt.users, token)
+		}
+	}
+	return nil
+}
+
+func (ctt *checkTokenTest) GetRefreshTokenSession(refreshToken string) (string, models.Session, error) {
+	userID, ok := ctt.users[refreshToken]
+	if !ok {
+		return "", models.Session{}, ErrRefreshTokenInvalid
+	}
+	return userID, models.Session{ID: "session1"}, nil
+}
+
+func (ctt *checkTokenTest) RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error {
+	return ctt.rotateErr
+}
+
+func (ctt *checkTokenTest) RevokeToken(token, tokenTypeHint string) error {
+	return nil
+}
+
+func TestCachedCheckToken(t *testing.T) {
+	Convey("Given a cache of two tokens", t, func() {
+		repo := &checkTokenTest{users: map[string]string{"token1": "user1", "token2": "user2", "token3": "user1"}}
+		cache := NewCachedUserRepository(repo, 2, time.Minute)
+
+		Convey("Valid tokens are only checked once", func() {
+			for i := 0; i < 3; i++ {
+				userID, err := cache.CheckToken("token1", "", "")
+				So(err, ShouldBeNil)
+				So(userID, ShouldEqual, "user1")
+			}
+			So(repo.checks, ShouldEqual, 1)
+			So(cache.Stats(), ShouldResemble, TokenCacheStats{Hits: 2, Misses: 1, Size: 1})
+		})
+
+		Convey("Invalid tokens are not cached", func() {
+			cache.CheckToken("unknown", "", "")
+			userID, _ := cache.CheckToken("unknown", "", "")
+			So(userID, ShouldBeEmpty)
+			So(repo.checks, ShouldEqual, 2)
+		})
+
+		Convey("The least recently used token is evicted", func() {
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token2", "", "")
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token3", "", "")
+			So(cache.Stats().Size, ShouldEqual, 2)
+			cache.CheckToken("token2", "", "")
+			So(repo.checks, ShouldEqual, 4)
+		})
+
+		Convey("The audience of opaque tokens is cached once checked", func() {
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token1", "", "api")
+			So(repo.checks, ShouldEqual, 2)
+			userID, err := cache.CheckToken("token1", "", "api")
+			So(err, ShouldBeNil)
+			So(userID, ShouldEqual, "user1")
+			_, err = cache.CheckToken("token1", "", "admin")
+			So(err, ShouldEqual, helpers.ErrTokenAudience)
+			So(repo.checks, ShouldEqual, 2)
+		})
+
+		Convey("Logging out removes the token at once", func() {
+			cache.CheckToken("token1", "", "")
+			So(cache.DeleteToken("token1"), ShouldBeNil)
+			userID, _ := cache.CheckToken("token1", "", "")
+			So(userID, ShouldBeEmpty)
+		})
+
+		Convey("Logging out all the sessions removes all the tokens of the user", func() {
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token2", "", "")
+			So(cache.DeleteSessions("user1"), ShouldBeNil)
+			So(cache.Stats().Size, ShouldEqual, 1)
+		})
+
+		Convey("Tokens used from another ip are checked again", func() {
+			cache.CheckToken("token1", "1.2.3.4", "")
+			cache.CheckToken("token1", "1.2.3.4", "")
+			So(repo.checks, ShouldEqual, 1)
+			cache.CheckToken("token1", "5.6.7.8", "")
+			So(repo.checks, ShouldEqual, 2)
+		})
+
+		Convey("Reusing a refresh token removes the tokens of its user", func() {
+			token, err := helpers.Tokenize("user1", "session1", "", helpers.CustomClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token2", "", "")
+			repo.rotateErr = ErrRefreshTokenReused
+			So(cache.RotateRefreshToken("refresh", token, "newRefresh"), ShouldEqual, ErrRefreshTokenReused)
+			So(cache.Stats().Size, ShouldEqual, 1)
+
+			Convey("Even if the new token is opaque", func() {
+				repo.users["refresh"] = "user2"
+				So(cache.RotateRefreshToken("refresh", "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", "newRefresh"), ShouldEqual, ErrRefreshTokenReused)
+				So(cache.Stats().Size, ShouldEqual, 0)
+			})
+		})
+
+		Convey("A successful refresh keeps the cache", func() {
+			cache.CheckToken("token1", "", "")
+			So(cache.RotateRefreshToken("refresh", "qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", "newRefresh"), ShouldBeNil)
+			So(cache.Stats().Size, ShouldEqual, 1)
+		})
+
+		Convey("Revoking a refresh token removes the tokens of its user", func() {
+			repo.users["refresh"] = "user1"
+			cache.CheckToken("token1", "", "")
+			cache.CheckToken("token2", "", "")
+			So(cache.RevokeToken("refresh", "refresh_token"), ShouldBeNil)
+			So(cache.Stats().Size, ShouldEqual, 1)
+		})
+
+		Convey("Revoking an unknown token keeps the cache", func() {
+			cache.CheckToken("token1", "", "")
+			So(cache.RevokeToken("unknown", "refresh_token"), ShouldBeNil)
+			So(cache.Stats().Size, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a stale token, it should be checked again", t, func() {
+		repo := &checkTokenTest{users: map[string]string{"token1": "user1"}}
+		cache := NewCachedUserRepository(repo, 2, time.Millisecond)
+		cache.CheckToken("token1", "", "")
+		time.Sleep(5 * time.Millisecond)
+		cache.CheckToken("token1", "", "")
+		So(repo.checks, ShouldEqual, 2)
+	})
+
+	Convey("Given a JWT, it should not be cached after it expires", t, func() {
+		token, err := helpers.Tokenize("user1", "session1", "", helpers.CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expires, ok := helpers.TokenExpiresAt(token)
+		So(ok, ShouldBeTrue)
+		So(expires, ShouldHappenBefore, time.Now().Add(helpers.AccessTokenLifetime()+time.Second))
+
+		repo := &checkTokenTest{users: map[string]string{token: "user1"}}
+		cache := NewCachedUserRepository(repo, 2, 24*time.Hour)
+		cache.CheckToken(token, "", "")
+		So(cache.lru.Front().Value.(*tokenCacheEntry).expires, ShouldEqual, expires)
+	})
+}
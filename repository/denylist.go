@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// denylistOverlap is how far back every refresh of a Denylist looks again, so revocations
+// committed late by slow transactions are not missed
+const denylistOverlap = time.Minute
+
+// RevokedToken is a token revoked before it expired
+type RevokedToken struct {
+	ID          string
+	Expires     time.Time
+	DateRevoked time.Time
+}
+
+// RevokedTokensLoader loads the revoked tokens that have not expired yet and were revoked since the given date
+type RevokedTokensLoader interface {
+	GetRevokedTokens(since time.Time) ([]RevokedToken, error)
+}
+
+// Denylist keeps in memory the ids of the revoked tokens that have not expired yet. It
+// is loaded from a RevokedTokensLoader and refreshed periodically once started.
+type Denylist struct {
+	store    RevokedTokensLoader
+	interval time.Duration
+
+	mu          sync.RWMutex
+	tokens      map[string]time.Time
+	lastRevoked time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDenylist creates an empty Denylist refreshed from store every interval
+func NewDenylist(store RevokedTokensLoader, interval time.Duration) *Denylist {
+	return &Denylist{
+		store:    store,
+		interval: interval,
+		tokens:   make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Load adds the tokens revoked since the last load and forgets the ones that have expired
+func (dl *Denylist) Load() error {
+	dl.mu.RLock()
+	since := dl.lastRevoked
+	dl.mu.RUnlock()
+	if !since.IsZero() {
+		since = since.Add(-denylistOverlap)
+	}
+
+	revoked, err := dl.store.GetRevokedTokens(since)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	for _, token := range revoked {
+		dl.tokens[token.ID] = token.Expires
+		if token.DateRevoked.After(dl.lastRevoked) {
+			dl.lastRevoked = token.DateRevoked
+		}
+	}
+	for id, expires := range dl.tokens {
+		if !now.Before(expires) {
+			delete(dl.tokens, id)
+		}
+	}
+	return nil
+}
+
+// Start refreshes the denylist in background until Stop is called
+func (dl *Denylist) Start() {
+	go func() {
+		defer close(dl.done)
+		ticker := time.NewTicker(dl.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dl.stop:
+				return
+			case <-ticker.C:
+			}
+			if err := dl.Load(); err != nil {
+				log.Printf("Failed refreshing the denylist: %v", err)
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the denylist
+func (dl *Denylist) Stop() {
+	close(dl.stop)
+	<-dl.done
+}
+
+// Add adds the given token id until it expires, without waiting for the next refresh
+func (dl *Denylist) Add(id string, expires time.Time) {
+	if id == "" {
+		return
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.tokens[id] = expires
+}
+
+// Contains checks if the given token id has been revoked
+func (dl *Denylist) Contains(id string) bool {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	_, revoked := dl.tokens[id]
+	return revoked
+}
+
+// Len returns how many revoked tokens are kept
+func (dl *Denylist) Len() int {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return len(dl.tokens)
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"errors"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/44r0n/SessionManager/data"
+	"github.com/44r0n/SessionManager/helpers"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var withDatabase = flag.Bool("database", false, "run database integration tests")
+
+type revokedTokensTest struct {
+	revoked []RevokedToken
+	since   []time.Time
+	err     error
+}
+
+func (rtt *revokedTokensTest) GetRevokedTokens(since time.Time) ([]RevokedToken, error) {
+	rtt.since = append(rtt.since, since)
+	var revoked []RevokedToken
+	for _, token := range rtt.revoked {
+		if !token.DateRevoked.Before(since) {
+			revoked = append(revoked, token)
+		}
+	}
+	return revoked, rtt.err
+}
+
+func TestDenylistLoad(t *testing.T) {
+	Convey("Given revoked tokens", t, func() {
+		now := time.Now()
+		store := &revokedTokensTest{revoked: []RevokedToken{
+			{ID: "jti1", Expires: now.Add(time.Hour), DateRevoked: now.Add(-time.Hour)},
+			{ID: "jti2", Expires: now.Add(time.Hour), DateRevoked: now},
+		}}
+		denylist := NewDenylist(store, time.Hour)
+		So(denylist.Load(), ShouldBeNil)
+		So(store.since[0].IsZero(), ShouldBeTrue)
+		So(denylist.Contains("jti1"), ShouldBeTrue)
+		So(denylist.Contains("jti2"), ShouldBeTrue)
+		So(denylist.Contains("jti3"), ShouldBeFalse)
+
+		Convey("Refreshes only load the recent revocations", func() {
+			store.revoked = append(store.revoked, RevokedToken{ID: "jti3", Expires: now.Add(time.Hour), DateRevoked: now.Add(time.Second)})
+			So(denylist.Load(), ShouldBeNil)
+			So(store.since[1], ShouldEqual, now.Add(-denylistOverlap))
+			So(denylist.Contains("jti3"), ShouldBeTrue)
+			So(denylist.Len(), ShouldEqual, 3)
+		})
+
+		Convey("Expired tokens are forgotten", func() {
+			denylist.Add("jti4", now.Add(-time.Second))
+			So(denylist.Load(), ShouldBeNil)
+			So(denylist.Contains("jti4"), ShouldBeFalse)
+		})
+
+		Convey("A failure keeps the loaded tokens", func() {
+			store.err = errors.New("No bd connection")
+			So(denylist.Load(), ShouldEqual, store.err)
+			So(denylist.Contains("jti1"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDenylistLoadFromDatabase(t *testing.T) {
+	if !*withDatabase {
+		t.Skip("needs the database")
+	}
+	Convey("Given a token revoked before the denylist is created", t, func() {
+		connString := helpers.GetConnString("../configuration/configuration.json")
+		repo, err := NewUserRepository(connString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		datab := database.NewDatabaseConnection(connString)
+		err = datab.ExecuteNonQuery("INSERT INTO revoked_tokens (jti, expires, date_revoked) VALUES ('denylistjti', NOW() + INTERVAL 1 HOUR, NOW() - INTERVAL 1 HOUR)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer datab.ExecuteNonQuery("DELETE FROM revoked_tokens where jti = 'denylistjti'")
+
+		Convey("The first load from a zero watermark loads it", func() {
+			denylist := NewDenylist(repo, time.Hour)
+			So(denylist.Load(), ShouldBeNil)
+			So(denylist.Contains("denylistjti"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestStatelessCheckToken(t *testing.T) {
+	Convey("Given a stateless repository", t, func() {
+		repo := &checkTokenTest{users: map[string]string{}}
+		store := &revokedTokensTest{}
+		denylist := NewDenylist(store, time.Hour)
+		stateless := NewStatelessUserRepository(repo, denylist)
+		token, err := helpers.Tokenize("user1", "session1", "", helpers.CustomClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("Valid tokens are checked without the database", func() {
+			userID, err := stateless.CheckToken(token, "", "")
+			So(err, ShouldBeNil)
+			So(userID, ShouldEqual, "user1")
+			So(repo.checks, ShouldEqual, 0)
+		})
+
+		Convey("Logged out tokens are not valid", func() {
+			So(stateless.DeleteToken(token), ShouldBeNil)
+			So(denylist.Contains(helpers.TokenID(token)), ShouldBeTrue)
+			userID, err := stateless.CheckToken(token, "", "")
+			So(err, ShouldBeNil)
+			So(userID, ShouldBeEmpty)
+		})
+
+		Convey("The tokens of logged out sessions are not valid before the next refresh", func() {
+			store.revoked = []RevokedToken{{ID: helpers.TokenID(token), Expires: time.Now().Add(time.Hour), DateRevoked: time.Now()}}
+			deleted, err := stateless.DeleteSession("user1", "session1")
+			So(err, ShouldBeNil)
+			So(deleted, ShouldBeTrue)
+			So(denylist.Contains(helpers.TokenID(token)), ShouldBeTrue)
+			userID, err := stateless.CheckToken(token, "", "")
+			So(err, ShouldBeNil)
+			So(userID, ShouldBeEmpty)
+		})
+
+		Convey("Logging out everywhere denylists every token at once", func() {
+			store.revoked = []RevokedToken{{ID: helpers.TokenID(token), Expires: time.Now().Add(time.Hour), DateRevoked: time.Now()}}
+			So(stateless.DeleteSessions("user1"), ShouldBeNil)
+			So(denylist.Contains(helpers.TokenID(token)), ShouldBeTrue)
+		})
+
+		Convey("Refreshing denylists the previous token at once", func() {
+			store.revoked = []RevokedToken{{ID: helpers.TokenID(token), Expires: time.Now().Add(time.Hour), DateRevoked: time.Now()}}
+			newToken, err := helpers.Tokenize("user1", "session1", "", helpers.CustomClaims{})
+			So(err, ShouldBeNil)
+			So(stateless.RotateRefreshToken("refresh", newToken, "newRefresh"), ShouldBeNil)
+			So(denylist.Contains(helpers.TokenID(token)), ShouldBeTrue)
+		})
+
+		Convey("Reusing a refresh token denylists the tokens of its session at once", func() {
+			store.revoked = []RevokedToken{{ID: helpers.TokenID(token), Expires: time.Now().Add(time.Hour), DateRevoked: time.Now()}}
+			repo.rotateErr = ErrRefreshTokenReused
+			So(stateless.RotateRefreshToken("refresh", "newToken", "newRefresh"), ShouldEqual, ErrRefreshTokenReused)
+			So(denylist.Contains(helpers.TokenID(token)), ShouldBeTrue)
+		})
+
+		Convey("Opaque and forged tokens are not valid", func() {
+			userID, err := stateless.CheckToken("qwepoinfsaldkjnvqpwoiuehfasdsckjndqo", "", "")
+			So(err, ShouldBeNil)
+			So(userID, ShouldBeEmpty)
+			_, err = stateless.CheckToken(token+"x", "", "")
+			So(err, ShouldEqual, helpers.ErrTokenInvalid)
+		})
+
+		Convey("Tokens are only valid for their audience", func() {
+			_, err := stateless.CheckToken(token, "", "api")
+			So(err, ShouldEqual, helpers.ErrTokenAudience)
+			audienceToken, err := helpers.Tokenize("user1", "session1", "api", helpers.CustomClaims{})
+			So(err, ShouldBeNil)
+			userID, err := stateless.CheckToken(audienceToken, "", "api")
+			So(err, ShouldBeNil)
+			So(userID, ShouldEqual, "user1")
+		})
+	})
+}
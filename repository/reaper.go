@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ExpiredSessionsDeleter deletes expired sessions, refresh tokens and revoked tokens in batches of at most batchSize rows
+type ExpiredSessionsDeleter interface {
+	DeleteExpiredSessions(batchSize int) (int64, error)
+	DeleteExpiredRefreshTokens(batchSize int) (int64, error)
+	DeleteExpiredRevokedTokens(batchSize int) (int64, error)
+}
+
+// ReaperStats are the results of the last run of a Reaper
+type ReaperStats struct {
+	LastRun              time.Time
+	Duration             time.Duration
+	Batches              int
+	SessionsDeleted      int64
+	RefreshTokensDeleted int64
+	RevokedTokensDeleted int64
+	Err                  error
+}
+
+// Reaper periodically deletes the expired sessions and refresh tokens
+type Reaper struct {
+	store     ExpiredSessionsDeleter
+	interval  time.Duration
+	batchSize int
+
+	mu    sync.Mutex
+	stats ReaperStats
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReaper creates a Reaper that runs every interval deleting batchSize rows per transaction
+func NewReaper(store ExpiredSessionsDeleter, interval time.Duration, batchSize int) *Reaper {
+	return &Reaper{
+		store:     store,
+		interval:  interval,
+		batchSize: batchSize,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the reaper in background until Stop is called
+func (rp *Reaper) Start() {
+	go func() {
+		defer close(rp.done)
+		ticker := time.NewTicker(rp.interval)
+		defer ticker.Stop()
+		for {
+			stats := rp.Run()
+			if stats.Err != nil {
+				log.Printf("Failed reaping sessions: %v", stats.Err)
+			} else {
+				log.Printf("Reaped %v sessions, %v refresh tokens and %v revoked tokens in %v", stats.SessionsDeleted, stats.RefreshTokensDeleted, stats.RevokedTokensDeleted, stats.Duration)
+			}
+
+			select {
+			case <-rp.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the reaper and waits for the current batch to finish
+func (rp *Reaper) Stop() {
+	close(rp.stop)
+	<-rp.done
+}
+
+// Stats returns the results of the last run
+func (rp *Reaper) Stats() ReaperStats {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.stats
+}
+
+// Run deletes the expired sessions, then the expired refresh tokens and revoked
+// tokens, one batch at a time, until there are none left or the reaper is stopped
+func (rp *Reaper) Run() ReaperStats {
+	stats := ReaperStats{LastRun: time.Now()}
+	stats.SessionsDeleted, stats.Err = rp.deleteInBatches(rp.store.DeleteExpiredSessions, &stats.Batches)
+	if stats.Err == nil {
+		stats.RefreshTokensDeleted, stats.Err = rp.deleteInBatches(rp.store.DeleteExpiredRefreshTokens, &stats.Batches)
+	}
+	if stats.Err == nil {
+		stats.RevokedTokensDeleted, stats.Err = rp.deleteInBatches(rp.store.DeleteExpiredRevokedTokens, &stats.Batches)
+	}
+	stats.Duration = time.Since(stats.LastRun)
+
+	rp.mu.Lock()
+	rp.stats = stats
+	rp.mu.Unlock()
+	return stats
+}
+
+func (rp *Reaper) deleteInBatches(deleteBatch func(batchSize int) (int64, error), batches *int) (int64, error) {
+	var total int64
+	for {
+		select {
+		case <-rp.stop:
+			return total, nil
+		default:
+		}
+
+		deleted, err := deleteBatch(rp.batchSize)
+		*batches++
+		total += deleted
+		if err != nil || deleted < int64(rp.batchSize) {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type expiredSessionsTest struct {
+	sessions      int64
+	refreshTokens int64
+	revokedTokens int64
+	err           error
+	batches       []int
+}
+
+func (est *expiredSessionsTest) deleteBatch(pending *int64, batchSize int) (int64, error) {
+	est.batches = append(est.batches, batchSize)
+	if est.err != nil {
+		return 0, est.err
+	}
+	deleted := *pending
+	if deleted > int64(batchSize) {
+		deleted = int64(batchSize)
+	}
+	*pending -= deleted
+	return deleted, nil
+}
+
+func (est *expiredSessionsTest) DeleteExpiredSessions(batchSize int) (int64, error) {
+	return est.deleteBatch(&est.sessions, batchSize)
+}
+
+func (est *expiredSessionsTest) DeleteExpiredRefreshTokens(batchSize int) (int64, error) {
+	return est.deleteBatch(&est.refreshTokens, batchSize)
+}
+
+func (est *expiredSessionsTest) DeleteExpiredRevokedTokens(batchSize int) (int64, error) {
+	return est.deleteBatch(&est.revokedTokens, batchSize)
+}
+
+func TestReaperRun(t *testing.T) {
+	Convey("Given expired sessions and refresh tokens", t, func() {
+		store := &expiredSessionsTest{sessions: 25, refreshTokens: 5, revokedTokens: 12}
+		reaper := NewReaper(store, time.Hour, 10)
+
+		Convey("They are deleted in bounded batches", func() {
+			stats := reaper.Run()
+			So(stats.Err, ShouldBeNil)
+			So(stats.SessionsDeleted, ShouldEqual, 25)
+			So(stats.RefreshTokensDeleted, ShouldEqual, 5)
+			So(stats.RevokedTokensDeleted, ShouldEqual, 12)
+			So(stats.Batches, ShouldEqual, 6)
+			So(store.batches, ShouldResemble, []int{10, 10, 10, 10, 10, 10})
+			So(reaper.Stats(), ShouldResemble, stats)
+		})
+
+		Convey("A failure stops the run and is reported", func() {
+			store.err = errors.New("No bd connection")
+			stats := reaper.Run()
+			So(stats.Err, ShouldEqual, store.err)
+			So(stats.Batches, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestReaperStop(t *testing.T) {
+	Convey("Given a started reaper, it stops cleanly", t, func() {
+		store := &expiredSessionsTest{sessions: 3}
+		reaper := NewReaper(store, time.Hour, 10)
+		reaper.Start()
+		for i := 0; i < 100 && reaper.Stats().LastRun.IsZero(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		reaper.Stop()
+		So(reaper.Stats().SessionsDeleted, ShouldEqual, 3)
+	})
+}
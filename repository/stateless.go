@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"log"
+
+	"github.com/44r0n/SessionManager/helpers"
+)
+
+// StatelessUserRepository is an IUserRepositoryInterface that checks tokens only by their
+// signature, expiration and a Denylist of revoked tokens, without querying the database.
+// Its checks do not update the last use of the sessions, so sessions are only kept alive
+// by refreshing their tokens, and opaque tokens are never valid.
+type StatelessUserRepository struct {
+	IUserRepositoryInterface
+	denylist *Denylist
+}
+
+// NewStatelessUserRepository creates a StatelessUserRepository in front of repo
+func NewStatelessUserRepository(repo IUserRepositoryInterface, denylist *Denylist) *StatelessUserRepository {
+	return &StatelessUserRepository{IUserRepositoryInterface: repo, denylist: denylist}
+}
+
+// CheckToken returns the user of the given JWT if it is valid and has not been revoked.
+// It returns an empty user for opaque and revoked tokens, and helpers.ErrTokenAudience
+// if an audience is given and the aud claim of the token is another one.
+func (sur *StatelessUserRepository) CheckToken(token, ip, audience string) (string, error) {
+	if helpers.IsOpaqueToken(token) {
+		return "", nil
+	}
+	claims, err := helpers.GetClaimsFromToken(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Id == "" || sur.denylist.Contains(claims.Id) {
+		return "", nil
+	}
+	if audience != "" && audience != claims.Audience {
+		return "", helpers.ErrTokenAudience
+	}
+	return claims.ID, nil
+}
+
+// DeleteToken deletes the session of the given token and denylists the token at once
+func (sur *StatelessUserRepository) DeleteToken(token string) error {
+	if err := sur.IUserRepositoryInterface.DeleteToken(token); err != nil {
+		return err
+	}
+	sur.revoke(token)
+	return nil
+}
+
+// DeleteSession deletes the given session of userID and denylists its token at once
+func (sur *StatelessUserRepository) DeleteSession(userID, sessionID string) (bool, error) {
+	deleted, err := sur.IUserRepositoryInterface.DeleteSession(userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		sur.reload()
+	}
+	return deleted, nil
+}
+
+// DeleteSessions deletes the sessions of userID and denylists their tokens at once
+func (sur *StatelessUserRepository) DeleteSessions(userID string) error {
+	if err := sur.IUserRepositoryInterface.DeleteSessions(userID); err != nil {
+		return err
+	}
+	sur.reload()
+	return nil
+}
+
+// RotateRefreshToken replaces the tokens of the session of the given refresh token and
+// denylists its previous access token at once, or every token of the session when the
+// refresh token is reused
+func (sur *StatelessUserRepository) RotateRefreshToken(refreshToken, newToken, newRefreshToken string) error {
+	err := sur.IUserRepositoryInterface.RotateRefreshToken(refreshToken, newToken, newRefreshToken)
+	if err == nil || err == ErrRefreshTokenReused {
+		sur.reload()
+	}
+	return err
+}
+
+// RevokeToken revokes the session of the given access or refresh token and denylists the
+// access token of the session at once
+func (sur *StatelessUserRepository) RevokeToken(token, tokenTypeHint string) error {
+	if err := sur.IUserRepositoryInterface.RevokeToken(token, tokenTypeHint); err != nil {
+		return err
+	}
+	if helpers.TokenID(token) == "" {
+		sur.reload()
+		return nil
+	}
+	sur.revoke(token)
+	return nil
+}
+
+func (sur *StatelessUserRepository) revoke(token string) {
+	if expires, ok := helpers.TokenExpiresAt(token); ok {
+		sur.denylist.Add(helpers.TokenID(token), expires)
+	}
+}
+
+// reload loads the tokens just revoked by the database, whose ids are not known here.
+// If it fails they are denylisted by the next refresh.
+func (sur *StatelessUserRepository) reload() {
+	if err := sur.denylist.Load(); err != nil {
+		log.Printf("Failed refreshing the denylist: %v", err)
+	}
+}
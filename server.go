@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	// Standard library packages
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/44r0n/SessionManager/helpers"
 	"github.com/44r0n/SessionManager/repository"
@@ -17,20 +22,94 @@ func main() {
 	// Instantiate a new router
 	r := httprouter.New()
 	const serverURL = "127.0.0.1:3000"
-	connString := helpers.GetConnString("configuration/configuration.json")
+	const configFile = "configuration/configuration.json"
+	connString := helpers.GetConnString(configFile)
+
+	if err := helpers.InitKeyring(configFile); err != nil {
+		log.Fatalf("Cannot load token keys: %v", err)
+	}
+
+	if err := helpers.InitBreachedPasswords(configFile); err != nil {
+		log.Fatalf("Cannot load breached passwords: %v", err)
+	}
+
+	if !helpers.ClientAuthenticationRequired() {
+		log.Printf("The OAuth endpoints are open to anyone, configure Clients to protect them")
+	}
 
 	// Get a UserController instance
 	repo, err := repository.NewUserRepository(connString)
 	if err != nil {
 		log.Fatalf("Cannot load user repository: %v", err)
 	}
-	uc := controllers.NewUserController(repo)
+	var userRepo repository.IUserRepositoryInterface = repo
+	var cache *repository.CachedUserRepository
+	var denylist *repository.Denylist
+	if helpers.VerificationMode() == helpers.VerificationModeStateless {
+		if helpers.TokenFormat() == helpers.TokenFormatOpaque {
+			log.Fatalf("Opaque tokens cannot be verified in stateless mode")
+		}
+		denylist = repository.NewDenylist(repo, helpers.DenylistInterval())
+		if err := denylist.Load(); err != nil {
+			log.Fatalf("Cannot load the denylist of revoked tokens: %v", err)
+		}
+		denylist.Start()
+		userRepo = repository.NewStatelessUserRepository(repo, denylist)
+	} else if helpers.TokenCacheSize() > 0 {
+		cache = repository.NewCachedUserRepository(repo, helpers.TokenCacheSize(), helpers.TokenCacheStaleness())
+		userRepo = cache
+	}
+	uc := controllers.NewUserController(userRepo)
 	r.POST("/Register", uc.Register)
 	r.POST("/Login", uc.Login)
 	r.POST("/Logout", uc.Logout)
+	r.POST("/Logout/all", uc.LogoutAll)
+	r.POST("/Password", uc.ChangePassword)
+	r.GET("/Sessions", uc.GetSessions)
+	r.DELETE("/Sessions/:id", uc.DeleteSession)
+	r.GET("/csrf", uc.CSRF)
 	r.POST("/Token/isValid", uc.CheckToken)
+	r.POST("/Token/refresh", uc.RefreshToken)
+	r.POST("/oauth/introspect", uc.Introspect)
+	r.POST("/oauth/revoke", uc.Revoke)
+
+	kc := controllers.NewKeyController()
+	r.GET("/.well-known/jwks.json", kc.JWKS)
+
+	// Delete the expired sessions in background
+	reaper := repository.NewReaper(repo, helpers.ReaperInterval(), helpers.ReaperBatchSize())
+	reaper.Start()
+
+	sc := controllers.NewStatsController(reaper, cache)
+	r.GET("/stats", sc.Stats)
+
+	server := &http.Server{Addr: serverURL, Handler: r}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed shutting down server: %v", err)
+		}
+	}()
 
 	log.Printf("Starting server at %v", serverURL)
 	// Fire up the server
-	log.Fatal(http.ListenAndServe(serverURL, r))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-closed
+	reaper.Stop()
+	if denylist != nil {
+		denylist.Stop()
+	}
+	log.Printf("Server stopped. Last reaper run: %+v", reaper.Stats())
+	if cache != nil {
+		log.Printf("Token cache: %+v", cache.Stats())
+	}
 }
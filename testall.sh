@@ -1,5 +1,7 @@
 #!/bin/bash
 ./initDocker.sh $1
+./initsql.sh -m $1 || exit 1
+./testapp.sh
 ./initsql.sh -t $1
 ./testapp.sh
 ./initsql.sh "" $1